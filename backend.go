@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+// Backend provides the simulator underlying a Sim.
+//
+// The default backend is the gpio-sim kernel module.
+// The [FakeBackend] provides a pure Go alternative that does not require
+// root permissions or gpio-sim, but also does not provide any gpiochips
+// that can be accessed via the GPIO uAPI.
+type Backend interface {
+	// live constructs the simulator for the sim and takes it live.
+	//
+	// The sim Name and the cfg of the Chips are populated prior to the call.
+	live(s *Sim) error
+
+	// close deconstructs the simulator for the sim.
+	close(s *Sim) error
+}
+
+// lineAttrs provides access to the attributes of the lines of a chip.
+type lineAttrs interface {
+	// line returns the attributes of the line at offset.
+	//
+	// The offset has already been checked to be in range.
+	line(offset int) lineAttr
+}
+
+// lineAttr provides access to the attributes of a line.
+//
+// The attributes, and their values, are those provided by gpio-sim in sysfs,
+// i.e. "pull" and "value".
+type lineAttr interface {
+	readAttr(attr string) (string, error)
+	writeAttr(attr, value string) error
+}
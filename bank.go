@@ -4,21 +4,31 @@
 
 package gpiosim
 
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
 // Bank contains the information required to configure a chip in a gpio-sim.
 type Bank struct {
 	// The number of lines simulated by this bank/chip.
-	NumLines int
+	NumLines int `json:"num_lines" yaml:"num_lines"`
 
 	// The label of the chip.
-	Label string
+	Label string `json:"label" yaml:"label"`
 
 	// Lines assigned an identifying name.
 	//
 	// Line names do not need to be unique.
-	Names map[int]string
+	Names map[int]string `json:"names,omitempty" yaml:"names,omitempty"`
 
 	// Lines that appear to be already in use by some other entity.
-	Hogs map[int]Hog
+	Hogs map[int]Hog `json:"hogs,omitempty" yaml:"hogs,omitempty"`
+
+	// Conflicts between the options applied by NewBank.
+	conflicts []error
 }
 
 // NewBank constructs a Bank with the label, numLines and options provided.
@@ -31,6 +41,9 @@ type Bank struct {
 // in the test.
 //
 // The available options are [WithNamedLine] and [WithHoggedLine].
+//
+// The bank is validated by NewSim, before any simulator is constructed,
+// and may be checked beforehand using Validate.
 func NewBank(label string, numLines int, options ...NewBankOption) *Bank {
 	b := &Bank{Label: label, NumLines: numLines}
 	for _, o := range options {
@@ -39,14 +52,60 @@ func NewBank(label string, numLines int, options ...NewBankOption) *Bank {
 	return b
 }
 
+// Validate checks the configuration of the bank.
+//
+// All the problems found are returned in a BankError.
+//
+// The bank is valid if NumLines is positive, all the named and hogged lines
+// are in the range 0..NumLines-1, all the hogs have a valid direction, and
+// NewBank was not provided conflicting options for the same line.
+func (b *Bank) Validate() error {
+	var problems []error
+	if b.NumLines <= 0 {
+		problems = append(problems, NumLinesError{b.NumLines})
+	}
+	for _, o := range sortedOffsets(b.Names) {
+		if o < 0 || o >= b.NumLines {
+			problems = append(problems, LineConfigError{o, "named line " + outOfRange(b.NumLines), ErrInvalidOffset})
+		}
+	}
+	for _, o := range sortedOffsets(b.Hogs) {
+		if o < 0 || o >= b.NumLines {
+			problems = append(problems, LineConfigError{o, "hogged line " + outOfRange(b.NumLines), ErrInvalidOffset})
+		}
+		if d := b.Hogs[o].Direction; d < HogDirectionInput || d > HogDirectionOutputHigh {
+			problems = append(problems, LineConfigError{Offset: o, Reason: fmt.Sprintf("invalid hog direction: %d", d)})
+		}
+	}
+	problems = append(problems, b.conflicts...)
+	if len(problems) != 0 {
+		return BankError{b.Label, problems}
+	}
+	return nil
+}
+
+func outOfRange(numLines int) string {
+	return fmt.Sprintf("out of range 0..%d", numLines-1)
+}
+
+// sortedOffsets returns the offsets of the map in ascending order.
+func sortedOffsets[V any](m map[int]V) []int {
+	offsets := make([]int, 0, len(m))
+	for o := range m {
+		offsets = append(offsets, o)
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
 // Hog contains the details of a line hog, i.e. some other user of a line.
 type Hog struct {
 	// The name of the consumer that appears to be using the line.
-	Consumer string
+	Consumer string `json:"consumer" yaml:"consumer"`
 
 	// The requested direction for the hogged line, and if an
 	// output then the direction of pull.
-	Direction HogDirection
+	Direction HogDirection `json:"direction" yaml:"direction"`
 }
 
 // HogDirection indicates the direction of a hogged line.
@@ -62,3 +121,50 @@ const (
 	// Hogged line is requested as an output pulled high.
 	HogDirectionOutputHigh
 )
+
+// MarshalText returns the direction as used when configuring the gpio-sim,
+// i.e. "input", "output-low" or "output-high".
+func (d HogDirection) MarshalText() ([]byte, error) {
+	if d < HogDirectionInput || d > HogDirectionOutputHigh {
+		return nil, errors.Errorf("invalid hog direction: %d", d)
+	}
+	return []byte(hogDirectionToString(d)), nil
+}
+
+// UnmarshalText sets the direction from the form returned by MarshalText.
+func (d *HogDirection) UnmarshalText(text []byte) error {
+	dir, err := hogDirectionFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*d = dir
+	return nil
+}
+
+// hogDirectionToString maps the HogDirection to the corresponding string
+// used when configuring the gpio-sim.
+func hogDirectionToString(d HogDirection) string {
+	switch d {
+	case HogDirectionOutputLow:
+		return "output-low"
+	case HogDirectionOutputHigh:
+		return "output-high"
+	default:
+		return "input"
+	}
+}
+
+// hogDirectionFromString maps the string used when configuring the gpio-sim
+// to the corresponding HogDirection.
+func hogDirectionFromString(d string) (HogDirection, error) {
+	switch d {
+	case "input":
+		return HogDirectionInput, nil
+	case "output-low":
+		return HogDirectionOutputLow, nil
+	case "output-high":
+		return HogDirectionOutputHigh, nil
+	default:
+		return HogDirectionInput, errors.Errorf("unexpected hog direction value: %s", d)
+	}
+}
@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warthog618/go-gpiosim"
+)
+
+func TestBankValidate(t *testing.T) {
+	patterns := []struct {
+		name     string
+		bank     *gpiosim.Bank
+		problems []string
+	}{
+		{
+			"valid",
+			gpiosim.NewBank("valid", 8,
+				gpiosim.WithNamedLine(0, "first"),
+				gpiosim.WithNamedLine(7, "last"),
+				gpiosim.WithHoggedLine(7, "piggy", gpiosim.HogDirectionOutputHigh),
+				gpiosim.WithNamedLine(7, "last"),
+			),
+			nil,
+		},
+		{
+			"zero lines",
+			gpiosim.NewBank("empty", 0),
+			[]string{"invalid num_lines: 0"},
+		},
+		{
+			"negative lines",
+			gpiosim.NewBank("negative", -2),
+			[]string{"invalid num_lines: -2"},
+		},
+		{
+			"offsets out of range",
+			gpiosim.NewBank("range", 8,
+				gpiosim.WithNamedLine(8, "over"),
+				gpiosim.WithNamedLine(-1, "under"),
+				gpiosim.WithHoggedLine(12, "piggy", gpiosim.HogDirectionInput),
+			),
+			[]string{
+				"line -1: named line out of range 0..7",
+				"line 8: named line out of range 0..7",
+				"line 12: hogged line out of range 0..7",
+			},
+		},
+		{
+			"invalid hog direction",
+			gpiosim.NewBank("direction", 8,
+				gpiosim.WithHoggedLine(3, "piggy", gpiosim.HogDirection(5)),
+			),
+			[]string{"line 3: invalid hog direction: 5"},
+		},
+		{
+			"conflicts",
+			gpiosim.NewBank("conflicts", 8,
+				gpiosim.WithNamedLine(3, "LED0"),
+				gpiosim.WithNamedLine(3, "LED1"),
+				gpiosim.WithHoggedLine(4, "piggy", gpiosim.HogDirectionInput),
+				gpiosim.WithHoggedLine(4, "piggy", gpiosim.HogDirectionOutputLow),
+			),
+			[]string{
+				"line 3: conflicting names 'LED0' and 'LED1'",
+				"line 4: conflicting hogs 'piggy' (input) and 'piggy' (output-low)",
+			},
+		},
+	}
+	for _, p := range patterns {
+		tf := func(t *testing.T) {
+			err := p.bank.Validate()
+			if p.problems == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, gpiosim.ErrInvalidBank)
+			var be gpiosim.BankError
+			require.ErrorAs(t, err, &be)
+			assert.Equal(t, p.bank.Label, be.Label)
+			problems := []string{}
+			for _, e := range be.Problems {
+				problems = append(problems, e.Error())
+			}
+			assert.Equal(t, p.problems, problems)
+		}
+		t.Run(p.name, tf)
+	}
+}
+
+func TestNewSimValidate(t *testing.T) {
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(gpiosim.NewFakeBackend()),
+		gpiosim.WithBank(gpiosim.NewBank("left", 0)),
+		gpiosim.WithBank(gpiosim.NewBank("middle", 8)),
+		gpiosim.WithBank(gpiosim.NewBank("right", 8,
+			gpiosim.WithHoggedLine(8, "piggy", gpiosim.HogDirectionInput),
+		)),
+	)
+	assert.Nil(t, s)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidBank)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidNumLines)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidOffset)
+	var ce gpiosim.ConfigError
+	require.ErrorAs(t, err, &ce)
+	require.Equal(t, 2, len(ce.Banks))
+	assert.Equal(t, "left", ce.Banks[0].Label)
+	assert.Equal(t, "right", ce.Banks[2].Label)
+	assert.NotErrorIs(t, ce.Banks[0], gpiosim.ErrInvalidOffset)
+	var ne gpiosim.NumLinesError
+	require.ErrorAs(t, ce.Banks[0].Problems[0], &ne)
+	assert.Equal(t, 0, ne.NumLines)
+	assert.NotErrorIs(t, ce.Banks[2], gpiosim.ErrInvalidNumLines)
+	var le gpiosim.LineConfigError
+	require.ErrorAs(t, ce.Banks[2].Problems[0], &le)
+	assert.Equal(t, 8, le.Offset)
+	assert.ErrorIs(t, le, gpiosim.ErrInvalidOffset)
+	assert.Equal(t,
+		"bank0: bank 'left': invalid num_lines: 0; "+
+			"bank2: bank 'right': line 8: hogged line out of range 0..7",
+		err.Error())
+}
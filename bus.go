@@ -0,0 +1,359 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/warthog618/go-gpiocdev/uapi"
+)
+
+// Bus emulates an open-drain, or wired-AND, bus connecting several lines.
+//
+// The bus is pulled up, and is low if any of the lines is a push-pull or
+// open-drain output driven low, or if any external driver is driving it low.
+// The level of the bus is reflected as the pull on the lines, so the lines
+// that are inputs read the level of the bus.
+//
+// With the gpio-sim backend, a line requested as open-drain remains an output
+// when it is released, by setting it high, and gpio-sim does not apply
+// changes to the pull of outputs.  So a released open-drain line does not
+// read the level of the bus, and may still be seen as driving the bus low.
+// Open-drain lines may drive the bus low, but lines should release the bus
+// by being reconfigured as inputs.
+type Bus struct {
+	lines []Line
+
+	// The readers of the drive of the lines, keyed by chip.
+	drives map[*Chip]driveReader
+
+	// The connections of the sim, from which the bus is removed when closed.
+	connections *connectionSet
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu sync.Mutex
+
+	// The pulls last applied to the lines, or -1 if not yet applied.
+	pulls []int
+
+	// The drives of the lines when last updated, or -1 if not yet updated.
+	lineDrives []int
+
+	// The external drivers driving the bus low.
+	external map[string]bool
+
+	// The current level of the bus.
+	level int
+
+	// The error that stopped the bus.
+	err error
+
+	closeOnce sync.Once
+}
+
+// Bus connects the lines with an emulated open-drain bus, until the Bus or the
+// sim is closed.
+//
+// The lines may be on different chips, but all must be in the sim.
+//
+// The levels of the lines are watched as per Chip.WatchLevel, and the lines
+// are also rechecked at the level poll interval, to detect lines being
+// reconfigured.
+// A line is considered to be driving the bus low if it is requested as a
+// push-pull or open-drain output and its level is low.
+// Such lines are pulled up, rather than to the level of the bus, so the bus
+// can detect when they are released.
+func (s *Sim) Bus(lines ...Line) (*Bus, error) {
+	if len(lines) == 0 {
+		return nil, errors.New("no lines on bus")
+	}
+	offsets := make(map[*Chip][]int)
+	var chips []*Chip
+	for i, l := range lines {
+		if !s.contains(l) {
+			return nil, errors.New("line is not in sim")
+		}
+		for _, p := range lines[:i] {
+			if p.chip == l.chip && p.offset == l.offset {
+				return nil, errors.Errorf("line %d repeated on bus", l.offset)
+			}
+		}
+		if _, ok := offsets[l.chip]; !ok {
+			chips = append(chips, l.chip)
+		}
+		offsets[l.chip] = append(offsets[l.chip], l.offset)
+	}
+	b := Bus{
+		lines:       lines,
+		drives:      make(map[*Chip]driveReader),
+		connections: s.connections,
+		done:        make(chan struct{}),
+		pulls:       make([]int, len(lines)),
+		lineDrives:  make([]int, len(lines)),
+		external:    make(map[string]bool),
+	}
+	for i := range b.pulls {
+		b.pulls[i] = -1
+		b.lineDrives[i] = -1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	var watches []<-chan LevelEvent
+	for _, c := range chips {
+		dr, err := newDriveReader(c)
+		if err == nil {
+			b.drives[c] = dr
+			var events <-chan LevelEvent
+			events, err = c.WatchLevel(ctx, offsets[c]...)
+			watches = append(watches, events)
+		}
+		if err != nil {
+			cancel()
+			b.closeDrives()
+			return nil, err
+		}
+	}
+	if err := b.update(); err != nil {
+		cancel()
+		b.closeDrives()
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	for i, events := range watches {
+		wg.Add(1)
+		go func(events <-chan LevelEvent, interval time.Duration) {
+			defer wg.Done()
+			b.watch(ctx, events, interval)
+		}(events, chips[i].pollInterval())
+	}
+	go func() {
+		wg.Wait()
+		b.closeDrives()
+		close(b.done)
+	}()
+	s.connections.add(&b)
+	return &b, nil
+}
+
+// Lines returns the lines connected to the bus.
+func (b *Bus) Lines() []Line {
+	return append([]Line(nil), b.lines...)
+}
+
+// Level returns the current level of the bus.
+func (b *Bus) Level() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.level
+}
+
+// DriveLow drives the bus low on behalf of the identified external driver,
+// such as a simulated device sharing the bus.
+//
+// The bus remains low until all external drivers have been released, and no
+// line is driving it low.
+func (b *Bus) DriveLow(driver string) error {
+	return b.setExternal(driver, true)
+}
+
+// Release stops the identified external driver driving the bus low.
+func (b *Bus) Release(driver string) error {
+	return b.setExternal(driver, false)
+}
+
+func (b *Bus) setExternal(driver string, low bool) error {
+	select {
+	case <-b.done:
+		if b.err != nil {
+			return b.err
+		}
+		return errors.New("bus closed")
+	default:
+	}
+	b.mu.Lock()
+	if low {
+		b.external[driver] = true
+	} else {
+		delete(b.external, driver)
+	}
+	b.mu.Unlock()
+	return b.update()
+}
+
+// Done returns a channel that is closed when the bus is disconnected, either
+// by Close or by a failure to access the lines.
+func (b *Bus) Done() <-chan struct{} {
+	return b.done
+}
+
+// Close disconnects the bus, leaving the pulls of the lines at their current
+// values.
+//
+// Returns the error that disconnected the bus, if it failed before being
+// closed.
+func (b *Bus) Close() error {
+	b.closeOnce.Do(func() {
+		b.cancel()
+		b.connections.remove(b)
+	})
+	<-b.done
+	return b.err
+}
+
+// watch updates the bus for each change to the level of a line, and
+// periodically to detect changes to the drive of the lines, as reconfiguring
+// a line does not necessarily change its level.
+func (b *Bus) watch(ctx context.Context, events <-chan LevelEvent, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				if ctx.Err() == nil {
+					b.fail(errors.New("failed to read level of line"))
+				}
+				return
+			}
+		case <-t.C:
+		}
+		if err := b.update(); err != nil {
+			b.fail(err)
+			return
+		}
+	}
+}
+
+func (b *Bus) fail(err error) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.mu.Unlock()
+	b.closeOnce.Do(b.cancel)
+}
+
+// update determines the level of the bus, and applies it to the pulls of the
+// lines.
+func (b *Bus) update() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	low := len(b.external) > 0
+	driving := make([]bool, len(b.lines))
+	for i, l := range b.lines {
+		drive, err := b.drives[l.chip].lineDrive(l.offset)
+		if err != nil {
+			return err
+		}
+		if drive != b.lineDrives[i] {
+			// gpio-sim only updates the level of a line reconfigured as an
+			// input when its pull is set, so the pull is reapplied
+			b.lineDrives[i] = drive
+			b.pulls[i] = -1
+		}
+		if drive != drivePushPull && drive != driveOpenDrain {
+			// inputs and open-source outputs cannot pull the bus low
+			continue
+		}
+		v, err := l.Level()
+		if err != nil {
+			return err
+		}
+		driving[i] = v == LevelInactive
+		low = low || driving[i]
+	}
+	b.level = LevelActive
+	if low {
+		b.level = LevelInactive
+	}
+	for i, l := range b.lines {
+		pull := b.level
+		if driving[i] {
+			// so a release is seen as a change in level
+			pull = LevelActive
+		}
+		if pull == b.pulls[i] {
+			continue
+		}
+		if err := l.SetPull(pull); err != nil {
+			return err
+		}
+		b.pulls[i] = pull
+	}
+	return nil
+}
+
+func (b *Bus) closeDrives() {
+	for _, dr := range b.drives {
+		dr.close()
+	}
+}
+
+// The drives of lines.
+const (
+	// The line is an input.
+	driveNone = iota
+
+	// The line is an output that drives both high and low.
+	drivePushPull
+
+	// The line is an output that only drives low.
+	driveOpenDrain
+
+	// The line is an output that only drives high.
+	driveOpenSource
+)
+
+// driveReader determines how the lines of a chip are driven.
+type driveReader interface {
+	lineDrive(offset int) (int, error)
+	close()
+}
+
+// newDriveReader returns the driveReader for the chip.
+//
+// Backends that track the direction of their lines provide their own, else
+// the drive is read from the gpiochip device using the GPIO uAPI.
+func newDriveReader(c *Chip) (driveReader, error) {
+	if dr, ok := c.lines.(driveReader); ok {
+		return dr, nil
+	}
+	f, err := os.Open(c.devPath)
+	if err != nil {
+		return nil, err
+	}
+	return cdevDrives{f}, nil
+}
+
+// cdevDrives reads the drive of lines from a gpiochip device.
+type cdevDrives struct {
+	f *os.File
+}
+
+func (d cdevDrives) lineDrive(offset int) (int, error) {
+	li, err := uapi.GetLineInfoV2(d.f.Fd(), offset)
+	if err != nil {
+		return driveNone, err
+	}
+	switch {
+	case !li.Flags.IsOutput():
+		return driveNone, nil
+	case li.Flags.IsOpenDrain():
+		return driveOpenDrain, nil
+	case li.Flags.IsOpenSource():
+		return driveOpenSource, nil
+	}
+	return drivePushPull, nil
+}
+
+func (d cdevDrives) close() {
+	d.f.Close()
+}
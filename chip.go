@@ -5,10 +5,7 @@
 package gpiosim
 
 import (
-	"fmt"
-	"path"
-
-	"github.com/pkg/errors"
+	"time"
 )
 
 // Chip provides the interface to a simulated gpiochip.
@@ -33,6 +30,15 @@ type Chip struct {
 
 	// The configuration for this chip
 	cfg Bank
+
+	// The attributes of the lines provided by the backend.
+	lines lineAttrs
+
+	// The period between checks of the levels of lines watched by WatchLevel,
+	// if the backend does not notify of changes.
+	//
+	// If zero then the defaultLevelPollInterval is used.
+	levelPollInterval time.Duration
 }
 
 // ChipName returns the name of the gpiochip.
@@ -56,23 +62,53 @@ func (c *Chip) DevPath() string {
 	return c.devPath
 }
 
+// Line returns the line at the given offset.
+//
+// Returns an InvalidOffsetError if the offset is outside the range of the
+// chip.
+func (c *Chip) Line(offset int) (Line, error) {
+	if err := c.checkOffset(offset); err != nil {
+		return Line{}, err
+	}
+	return c.line(offset), nil
+}
+
+// FindLine returns the line with the given name.
+//
+// Line names need not be unique, so returns an AmbiguousLineError if more
+// than one line on the chip has the name, or a LineNotFoundError if none do.
+// Use FindLines to find all the lines with the name.
+func (c *Chip) FindLine(name string) (Line, error) {
+	return uniqueLine(name, c.FindLines(name))
+}
+
+// FindLines returns all the lines with the given name, in order of offset.
+func (c *Chip) FindLines(name string) []Line {
+	var lines []Line
+	for _, o := range sortedOffsets(c.cfg.Names) {
+		if c.cfg.Names[o] == name {
+			lines = append(lines, c.line(o))
+		}
+	}
+	return lines
+}
+
+// line returns the line at the given offset, which must be in range.
+func (c *Chip) line(offset int) Line {
+	return Line{chip: c, offset: offset, attrs: c.lines.line(offset)}
+}
+
 // Level returns the level the line is being pulled to.
 //
 // If the line is requested as an output then this is the level userspace is
 // driving it to, and otherwise there is little point calling this method -
 // you probably should be calling Pull instead.
 func (c *Chip) Level(offset int) (int, error) {
-	v, err := c.attr(offset, "value")
-	if err == nil {
-		if v == "0" {
-			return LevelInactive, nil
-		}
-		if v == "1" {
-			return LevelActive, nil
-		}
-		err = errors.Errorf("unexpected level value: %s", v)
+	l, err := c.Line(offset)
+	if err != nil {
+		return LevelInactive, err
 	}
-	return LevelInactive, err
+	return l.Level()
 }
 
 const (
@@ -85,17 +121,11 @@ const (
 
 // Pull returns the current the pull of the given line.
 func (c *Chip) Pull(offset int) (int, error) {
-	v, err := c.attr(offset, "pull")
-	if err == nil {
-		if v == "pull-down" {
-			return LevelInactive, nil
-		}
-		if v == "pull-up" {
-			return LevelActive, nil
-		}
-		err = errors.Errorf("unexpected pull value: %s", v)
+	l, err := c.Line(offset)
+	if err != nil {
+		return LevelInactive, err
 	}
-	return LevelInactive, err
+	return l.Pull()
 }
 
 // Pulldown sets the pull of the given line to pull-down.
@@ -110,35 +140,96 @@ func (c *Chip) Pullup(offset int) error {
 
 // SetPull sets the pull of the given line.
 func (c *Chip) SetPull(offset int, level int) error {
-	l := "pull-down"
-	if level == LevelActive {
-		l = "pull-up"
+	l, err := c.Line(offset)
+	if err != nil {
+		return err
 	}
-	return c.setAttr(offset, "pull", l)
+	return l.SetPull(level)
+}
+
+// SetPulls sets the pulls of several lines, with the map being from offset
+// to pull.
+//
+// All the offsets are checked before any pulls are set.
+// gpio-sim provides no way to set several pulls atomically, so the pulls are
+// set one line at a time, in order of offset.
+func (c *Chip) SetPulls(pulls map[int]int) error {
+	offsets := sortedOffsets(pulls)
+	for _, o := range offsets {
+		if err := c.checkOffset(o); err != nil {
+			return err
+		}
+	}
+	for _, o := range offsets {
+		if err := c.line(o).SetPull(pulls[o]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetPullsMask sets the pulls of the lines selected by the mask to the value
+// of the corresponding bit in bits.
+//
+// Bit n of the mask and bits corresponds to the line at offset n, so only the
+// first 64 lines of a chip can be set this way.
+//
+// All the offsets are checked before any pulls are set.
+func (c *Chip) SetPullsMask(mask, bits uint64) error {
+	pulls := make(map[int]int)
+	for o := 0; o < 64; o++ {
+		if mask&(1<<o) != 0 {
+			pulls[o] = int(bits>>o) & 1
+		}
+	}
+	return c.SetPulls(pulls)
+}
+
+// Levels returns the levels of several lines, in the order of the offsets.
+//
+// If no offsets are provided then the levels of all the lines of the chip are
+// returned.
+//
+// All the offsets are checked before any levels are read.
+func (c *Chip) Levels(offsets ...int) ([]int, error) {
+	if len(offsets) == 0 {
+		offsets = make([]int, c.cfg.NumLines)
+		for i := range offsets {
+			offsets[i] = i
+		}
+	}
+	for _, o := range offsets {
+		if err := c.checkOffset(o); err != nil {
+			return nil, err
+		}
+	}
+	levels := make([]int, len(offsets))
+	for i, o := range offsets {
+		v, err := c.line(o).Level()
+		if err != nil {
+			return nil, err
+		}
+		levels[i] = v
+	}
+	return levels, nil
 }
 
 // Toggle flips the pull of the given line.
 //
 // If it was pull-up it becomes pull-down, and vice versa.
 func (c *Chip) Toggle(offset int) error {
-	p, err := c.Pull(offset)
+	l, err := c.Line(offset)
 	if err != nil {
 		return err
 	}
-	if p == 0 {
-		p = 1
-	} else {
-		p = 0
-	}
-	return c.SetPull(offset, p)
+	return l.Toggle()
 }
 
-// attr reads the given line attribute from sysfs
-func (c *Chip) attr(offset int, name string) (string, error) {
-	return readAttr(path.Join(c.sysfsPath, fmt.Sprintf("sim_gpio%d", offset)), name)
-}
-
-// setAttr writes the given line attribute to sysfs
-func (c *Chip) setAttr(offset int, name, value string) error {
-	return writeAttr(path.Join(c.sysfsPath, fmt.Sprintf("sim_gpio%d", offset)), name, value)
+// checkOffset returns an InvalidOffsetError if the offset is outside the
+// range of the chip.
+func (c *Chip) checkOffset(offset int) error {
+	if offset < 0 || offset >= c.cfg.NumLines {
+		return InvalidOffsetError{offset, c.cfg.NumLines}
+	}
+	return nil
 }
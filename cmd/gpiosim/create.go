@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/warthog618/go-gpiosim"
+)
+
+func create(args []string) error {
+	fs := newFlagSet("create")
+	name := fs.String("name", "", "the name of the sim (default is generated, and the sim is orphaned once gpiosim exits)")
+	config := fs.String("config", "", "a YAML or JSON file describing the sim")
+	hold := fs.Bool("hold", false, "hold the sim until interrupted, then remove it")
+	dryRun := fs.Bool("dry-run", false, "print the equivalent shell commands rather than creating the sim")
+	args, err := parseArgs(fs, args, 0, -1)
+	if err != nil {
+		return err
+	}
+	var cfg gpiosim.Config
+	if len(*config) != 0 {
+		if cfg, err = gpiosim.LoadConfig(*config); err != nil {
+			return err
+		}
+	}
+	if len(*name) != 0 {
+		cfg.Name = *name
+	}
+	for _, a := range args {
+		b, err := parseBank(a)
+		if err != nil {
+			return err
+		}
+		cfg.Banks = append(cfg.Banks, *b)
+	}
+	if *dryRun {
+		return printScript(cfg)
+	}
+	s, err := gpiosim.NewSimFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	printSim(s)
+	if !*hold {
+		// the sim outlives the tool, so is deliberately not closed
+		return nil
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Println("holding sim - interrupt to remove")
+	<-ctx.Done()
+	return s.Close()
+}
+
+// parseBank parses a bank in the form label:num_lines.
+//
+// The label may itself contain colons.
+func parseBank(arg string) (*gpiosim.Bank, error) {
+	i := strings.LastIndex(arg, ":")
+	if i < 0 {
+		return nil, errors.Errorf("invalid bank '%s' - expected label:num_lines", arg)
+	}
+	numLines, err := strconv.Atoi(arg[i+1:])
+	if err != nil {
+		return nil, errors.Errorf("invalid num_lines in bank '%s'", arg)
+	}
+	return gpiosim.NewBank(arg[:i], numLines), nil
+}
+
+func printScript(cfg gpiosim.Config) error {
+	options := make([]gpiosim.NewSimOption, 0, len(cfg.Banks)+1)
+	if len(cfg.Name) != 0 {
+		options = append(options, gpiosim.WithName(cfg.Name))
+	}
+	for i := range cfg.Banks {
+		options = append(options, gpiosim.WithBank(&cfg.Banks[i]))
+	}
+	script, err := gpiosim.NewScript(options...)
+	if err != nil {
+		return err
+	}
+	fmt.Print(script.Setup)
+	return nil
+}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/warthog618/go-gpiosim"
+)
+
+func pull(args []string) error {
+	fs := newFlagSet("pull")
+	args, err := parseArgs(fs, args, 4, 4)
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.Atoi(args[2])
+	if err != nil {
+		return errors.Errorf("invalid offset: %s", args[2])
+	}
+	var p int
+	switch args[3] {
+	case "up":
+		p = gpiosim.LevelActive
+	case "down":
+		p = gpiosim.LevelInactive
+	default:
+		return errors.Errorf("invalid pull '%s' - expected up or down", args[3])
+	}
+	s, c, err := openSim(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return c.SetPull(offset, p)
+}
+
+func level(args []string) error {
+	fs := newFlagSet("level")
+	args, err := parseArgs(fs, args, 2, -1)
+	if err != nil {
+		return err
+	}
+	s, c, err := openSim(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	offsets, err := parseOffsets(c, args[2:])
+	if err != nil {
+		return err
+	}
+	for _, o := range offsets {
+		v, err := c.Level(o)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d=%d\n", o, v)
+	}
+	return nil
+}
+
+func toggle(args []string) error {
+	fs := newFlagSet("toggle")
+	args, err := parseArgs(fs, args, 3, 3)
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.Atoi(args[2])
+	if err != nil {
+		return errors.Errorf("invalid offset: %s", args[2])
+	}
+	s, c, err := openSim(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := c.Toggle(offset); err != nil {
+		return err
+	}
+	p, err := c.Pull(offset)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d pulled %s\n", offset, pullString(p))
+	return nil
+}
+
+// watch reports changes to the levels of the lines until interrupted.
+func watch(args []string) error {
+	fs := newFlagSet("watch")
+	period := fs.Duration("period", 100*time.Millisecond, "the period between polls of the lines, if the kernel does not notify of changes")
+	args, err := parseArgs(fs, args, 2, -1)
+	if err != nil {
+		return err
+	}
+	if *period <= 0 {
+		return errors.Errorf("invalid period: %s", *period)
+	}
+	s, c, err := openSim(args[0], args[1], gpiosim.WithLevelPollInterval(*period))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	offsets, err := parseOffsets(c, args[2:])
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	events, err := c.WatchLevel(ctx, offsets...)
+	if err != nil {
+		return err
+	}
+	// the initial levels are read after the watch is armed, so no changes are
+	// missed, and events that repeat the last printed level are dropped, so a
+	// change before the read is not printed twice
+	levels, err := c.Levels(offsets...)
+	if err != nil {
+		return err
+	}
+	printed := make(map[int]int)
+	for i, o := range offsets {
+		fmt.Printf("%d=%d\n", o, levels[i])
+		printed[o] = levels[i]
+	}
+	for evt := range events {
+		if printed[evt.Offset] == evt.Level {
+			continue
+		}
+		printed[evt.Offset] = evt.Level
+		fmt.Printf("%s %d=%d\n", evt.Timestamp.Format("15:04:05.000"), evt.Offset, evt.Level)
+	}
+	if ctx.Err() == nil {
+		return errors.New("watch failed reading levels")
+	}
+	return nil
+}
+
+func clean(args []string) error {
+	fs := newFlagSet("clean")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	removed, err := gpiosim.CleanupOrphans()
+	for _, name := range removed {
+		fmt.Printf("removed %s\n", name)
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/warthog618/go-gpiosim"
+)
+
+func list(args []string) error {
+	fs := newFlagSet("list")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	sims, err := gpiosim.ListSims()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPID\tLIVE\tORPHANED")
+	for _, s := range sims {
+		pid := "-"
+		if s.PID != 0 {
+			pid = fmt.Sprint(s.PID)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%t\n", s.Name, pid, s.Live, s.Orphaned)
+	}
+	return w.Flush()
+}
+
+func show(args []string) error {
+	fs := newFlagSet("show")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	s, err := gpiosim.OpenSim(args[0])
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	printSim(s)
+	for i := range s.Chips {
+		fmt.Println()
+		if err := printLines(&s.Chips[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printSim prints the sim and its chips.
+func printSim(s *gpiosim.Sim) {
+	fmt.Printf("sim %s\n", s.Name)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHIP\tNAME\tLABEL\tLINES\tDEVICE")
+	for i, c := range s.Chips {
+		cfg := c.Config()
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", i, c.ChipName(), cfg.Label, cfg.NumLines, c.DevPath())
+	}
+	w.Flush()
+}
+
+// printLines prints the configuration and state of the configured lines of
+// the chip.
+func printLines(c *gpiosim.Chip) error {
+	cfg := c.Config()
+	offsets := make(map[int]bool)
+	for o := range cfg.Names {
+		offsets[o] = true
+	}
+	for o := range cfg.Hogs {
+		offsets[o] = true
+	}
+	sorted := make([]int, 0, len(offsets))
+	for o := range offsets {
+		sorted = append(sorted, o)
+	}
+	sort.Ints(sorted)
+	fmt.Printf("%s (%s)\n", c.ChipName(), cfg.Label)
+	if len(sorted) == 0 {
+		fmt.Println("  no named or hogged lines")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  OFFSET\tNAME\tHOG\tPULL\tLEVEL")
+	for _, o := range sorted {
+		hog := "-"
+		if h, ok := cfg.Hogs[o]; ok {
+			dir, _ := h.Direction.MarshalText()
+			hog = fmt.Sprintf("%s (%s)", h.Consumer, dir)
+		}
+		pull, err := c.Pull(o)
+		if err != nil {
+			return err
+		}
+		level, err := c.Level(o)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "  %d\t%s\t%s\t%s\t%d\n", o, cfg.Names[o], hog, pullString(pull), level)
+	}
+	return w.Flush()
+}
+
+func pullString(pull int) string {
+	if pull == gpiosim.LevelActive {
+		return "up"
+	}
+	return "down"
+}
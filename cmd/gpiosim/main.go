@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+// gpiosim is a tool to create, inspect and drive GPIO simulators.
+//
+// It is intended to assist in debugging tests that use gpiosim, and is
+// built on the same API as the tests.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/warthog618/go-gpiosim"
+)
+
+// command is a subcommand of the tool.
+type command struct {
+	// The arguments of the command, excluding flags, for the usage.
+	args string
+
+	// A short description of the command.
+	help string
+
+	// Runs the command, with the arguments following the command name.
+	run func(args []string) error
+}
+
+// commands contains the available commands, keyed by name.
+//
+// Initialised in init, as the commands refer to the map for their usage.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"create": {"[label:num_lines ...]", "create a sim", create},
+		"list":   {"", "list the existing sims", list},
+		"show":   {"<sim>", "show the configuration and lines of a sim", show},
+		"pull":   {"<sim> <chip> <offset> up|down", "set the pull of a line", pull},
+		"level":  {"<sim> <chip> [offset ...]", "show the level of lines", level},
+		"toggle": {"<sim> <chip> <offset>", "toggle the pull of a line", toggle},
+		"watch":  {"<sim> <chip> [offset ...]", "report changes to the level of lines", watch},
+		"clean":  {"", "remove sims created by processes that are no longer running", clean},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "--help" {
+			fmt.Fprintf(os.Stderr, "gpiosim: unknown command '%s'\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "gpiosim %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gpiosim <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", n, commands[n].help)
+	}
+	fmt.Fprintln(os.Stderr, "\nUse \"gpiosim <command> -h\" for the flags and arguments of a command.")
+}
+
+// newFlagSet returns the flag set for the named command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		cmd := commands[name]
+		fmt.Fprintf(fs.Output(), "Usage: gpiosim %s [flags] %s\n\n%s.\n", name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the flags and checks the number of remaining arguments is
+// in the range min..max, with a negative max meaning no limit.
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	args = fs.Args()
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+	return args, nil
+}
+
+// openSim attaches to the named sim, and finds the identified chip.
+//
+// The chip may be identified by its index in the sim, by its chip name, or
+// by its label.
+func openSim(name, chip string, options ...gpiosim.OpenSimOption) (*gpiosim.Sim, *gpiosim.Chip, error) {
+	s, err := gpiosim.OpenSim(name, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := findChip(s, chip)
+	if err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+	return s, c, nil
+}
+
+func findChip(s *gpiosim.Sim, id string) (*gpiosim.Chip, error) {
+	if i, err := strconv.Atoi(id); err == nil && i >= 0 && i < len(s.Chips) {
+		return &s.Chips[i], nil
+	}
+	for i := range s.Chips {
+		if s.Chips[i].ChipName() == id {
+			return &s.Chips[i], nil
+		}
+	}
+	for i := range s.Chips {
+		if s.Chips[i].Config().Label == id {
+			return &s.Chips[i], nil
+		}
+	}
+	return nil, errors.Errorf("chip '%s' not found in sim '%s'", id, s.Name)
+}
+
+// parseOffsets parses the offsets, or returns all the offsets of the chip if
+// none are provided.
+func parseOffsets(c *gpiosim.Chip, args []string) ([]int, error) {
+	if len(args) == 0 {
+		offsets := make([]int, c.Config().NumLines)
+		for i := range offsets {
+			offsets[i] = i
+		}
+		return offsets, nil
+	}
+	offsets := make([]int, len(args))
+	for i, a := range args {
+		o, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, errors.Errorf("invalid offset: %s", a)
+		}
+		offsets[i] = o
+	}
+	return offsets, nil
+}
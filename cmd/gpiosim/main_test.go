@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warthog618/go-gpiosim"
+)
+
+func TestParseBank(t *testing.T) {
+	b, err := parseBank("left:8")
+	require.Nil(t, err)
+	assert.Equal(t, gpiosim.NewBank("left", 8), b)
+
+	b, err = parseBank("a:b:3")
+	require.Nil(t, err)
+	assert.Equal(t, gpiosim.NewBank("a:b", 3), b)
+
+	b, err = parseBank("left:eight")
+	assert.NotNil(t, err)
+	assert.Nil(t, b)
+
+	b, err = parseBank("left")
+	assert.NotNil(t, err)
+	assert.Nil(t, b)
+}
+
+func TestFindChip(t *testing.T) {
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(gpiosim.NewFakeBackend()),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8)),
+		gpiosim.WithBank(gpiosim.NewBank("right", 4)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	patterns := []struct {
+		id   string
+		chip *gpiosim.Chip
+	}{
+		{"0", &s.Chips[0]},
+		{"1", &s.Chips[1]},
+		{s.Chips[1].ChipName(), &s.Chips[1]},
+		{"left", &s.Chips[0]},
+		{"right", &s.Chips[1]},
+		{"2", nil},
+		{"middle", nil},
+	}
+	for _, p := range patterns {
+		c, err := findChip(s, p.id)
+		assert.Same(t, p.chip, c, p.id)
+		if p.chip == nil {
+			assert.NotNil(t, err, p.id)
+		}
+	}
+}
+
+func TestParseOffsets(t *testing.T) {
+	c := gpiosim.Chip{}
+	offsets, err := parseOffsets(&c, []string{"3", "1"})
+	require.Nil(t, err)
+	assert.Equal(t, []int{3, 1}, offsets)
+
+	_, err = parseOffsets(&c, []string{"3", "x"})
+	assert.NotNil(t, err)
+}
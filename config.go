@@ -0,0 +1,327 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a sim in a form that can be stored in a file.
+type Config struct {
+	// The name of the sim.
+	//
+	// If empty then NewSimFromConfig generates a unique name.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	// The banks of the sim, in the order of the chips.
+	Banks []Bank `json:"banks" yaml:"banks"`
+}
+
+// NewSimFromConfig constructs a Sim from the configuration.
+//
+// The options are applied after the configuration, so WithName overrides the
+// name in the configuration, and banks added using WithBank follow those in
+// the configuration.
+func NewSimFromConfig(cfg Config, options ...NewSimOption) (*Sim, error) {
+	opts := make([]NewSimOption, 0, len(cfg.Banks)+len(options)+1)
+	if len(cfg.Name) != 0 {
+		opts = append(opts, WithName(cfg.Name))
+	}
+	for i := range cfg.Banks {
+		opts = append(opts, WithBank(&cfg.Banks[i]))
+	}
+	return NewSim(append(opts, options...)...)
+}
+
+// NewSimFromFile constructs a Sim from the configuration in the named file.
+//
+// The file format is described by LoadConfig, and the options are applied as
+// per NewSimFromConfig.
+func NewSimFromFile(filename string, options ...NewSimOption) (*Sim, error) {
+	cfg, err := LoadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewSimFromConfig(cfg, options...)
+}
+
+// LoadConfig reads a sim configuration from the named file.
+//
+// The file may be either YAML or JSON, with the fields of Config, Bank and
+// Hog, e.g.
+//
+//	name: board
+//	banks:
+//	  - label: left
+//	    num_lines: 8
+//	    names:
+//	      3: LED0
+//	    hogs:
+//	      2: {consumer: piggy, direction: output-low}
+//
+// In addition to the checks made by Bank.Validate, unknown fields and
+// repeated fields or offsets are rejected.  All the problems found are
+// returned in a ConfigFileError, with the line and column where each was
+// found.
+func LoadConfig(filename string) (Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Config{}, err
+	}
+	return parseConfig(filename, data)
+}
+
+// parseConfig decodes the configuration, recording the location of any
+// problems.
+//
+// JSON is a subset of YAML, so both are decoded by the YAML parser.
+// The document is decoded from the node tree, rather than directly into the
+// Config, so the location of semantic problems, such as an out of range
+// offset, can be reported.
+func parseConfig(filename string, data []byte) (Config, error) {
+	var cfg Config
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return cfg, ConfigFileError{filename, []ConfigProblem{syntaxProblem(err)}}
+	}
+	var d configDecoder
+	if len(doc.Content) == 0 {
+		d.problems = append(d.problems, ConfigProblem{Reason: "empty config"})
+	} else {
+		cfg = d.config(doc.Content[0])
+	}
+	if len(d.problems) != 0 {
+		return Config{}, ConfigFileError{filename, d.problems}
+	}
+	return cfg, nil
+}
+
+// yamlErrorRegexp matches the location in errors returned by the YAML parser.
+var yamlErrorRegexp = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func syntaxProblem(err error) ConfigProblem {
+	m := yamlErrorRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return ConfigProblem{Reason: err.Error()}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return ConfigProblem{Line: line, Reason: m[2]}
+}
+
+// configDecoder decodes a Config from a YAML node tree.
+type configDecoder struct {
+	problems []ConfigProblem
+}
+
+func (d *configDecoder) fail(n *yaml.Node, format string, args ...interface{}) {
+	d.problems = append(d.problems, ConfigProblem{n.Line, n.Column, fmt.Sprintf(format, args...)})
+}
+
+func (d *configDecoder) config(n *yaml.Node) Config {
+	var cfg Config
+	var banks *yaml.Node
+	ok := d.fields(n, "config", func(k string, v *yaml.Node) bool {
+		switch k {
+		case "name":
+			cfg.Name = d.string(v, "name")
+		case "banks":
+			banks = resolve(v)
+		default:
+			return false
+		}
+		return true
+	})
+	switch {
+	case !ok:
+	case banks == nil:
+		d.fail(n, "missing banks")
+	case d.kind(banks, yaml.SequenceNode, "banks"):
+		if len(banks.Content) == 0 {
+			d.fail(banks, "no banks defined")
+		}
+		for _, b := range banks.Content {
+			cfg.Banks = append(cfg.Banks, d.bank(b))
+		}
+	}
+	return cfg
+}
+
+func (d *configDecoder) bank(n *yaml.Node) Bank {
+	var b Bank
+	var numLines, names, hogs *yaml.Node
+	ok := d.fields(n, "bank", func(k string, v *yaml.Node) bool {
+		switch k {
+		case "label":
+			b.Label = d.string(v, "label")
+		case "num_lines":
+			numLines = v
+		case "names":
+			names = v
+		case "hogs":
+			hogs = v
+		default:
+			return false
+		}
+		return true
+	})
+	if !ok {
+		return b
+	}
+	if numLines == nil {
+		d.fail(n, "missing num_lines")
+	} else if nl, ok := d.int(numLines, "num_lines"); ok {
+		if nl <= 0 {
+			d.fail(numLines, "invalid num_lines: %d", nl)
+		}
+		b.NumLines = nl
+	}
+	// the lines are decoded after num_lines, wherever that appears in the
+	// bank, so the offsets can be range checked
+	if names != nil {
+		d.offsets(names, "names", b.NumLines, func(o int, v *yaml.Node) {
+			NamedLine{o, d.string(v, "name")}.applyBankOption(&b)
+		})
+	}
+	if hogs != nil {
+		d.offsets(hogs, "hogs", b.NumLines, func(o int, v *yaml.Node) {
+			HoggedLine{o, d.hog(v)}.applyBankOption(&b)
+		})
+	}
+	return b
+}
+
+func (d *configDecoder) hog(n *yaml.Node) Hog {
+	var h Hog
+	d.fields(n, "hog", func(k string, v *yaml.Node) bool {
+		switch k {
+		case "consumer":
+			h.Consumer = d.string(v, "consumer")
+		case "direction":
+			dir := d.string(v, "direction")
+			var err error
+			if h.Direction, err = hogDirectionFromString(dir); err != nil {
+				d.fail(v, "invalid hog direction: %s", dir)
+			}
+		default:
+			return false
+		}
+		return true
+	})
+	return h
+}
+
+// offsets decodes a mapping keyed by line offset, checking that the offsets
+// are in range and are not repeated.
+//
+// The range is only checked if numLines is valid.
+func (d *configDecoder) offsets(n *yaml.Node, field string, numLines int, fn func(int, *yaml.Node)) {
+	n = resolve(n)
+	if !d.kind(n, yaml.MappingNode, field) {
+		return
+	}
+	seen := make(map[int]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := resolve(n.Content[i]), n.Content[i+1]
+		var ok bool
+		var o int
+		var err error
+		if k.Kind == yaml.ScalarNode && k.ShortTag() == "!!str" {
+			// JSON object keys are always strings
+			if o, err = strconv.Atoi(k.Value); err != nil {
+				d.fail(k, "offset must be an integer")
+				continue
+			}
+		} else if o, ok = d.int(k, "offset"); !ok {
+			continue
+		}
+		if numLines > 0 && (o < 0 || o >= numLines) {
+			d.fail(k, "offset %d %s", o, outOfRange(numLines))
+			continue
+		}
+		if seen[o] {
+			d.fail(k, "offset %d repeated in %s", o, field)
+			continue
+		}
+		seen[o] = true
+		fn(o, v)
+	}
+}
+
+// fields calls fn for each of the fields of a mapping, checking that the
+// keys are strings and are not repeated.
+//
+// fn returns false if the field is unknown.
+//
+// Returns false if the node is not a mapping.
+func (d *configDecoder) fields(n *yaml.Node, what string, fn func(string, *yaml.Node) bool) bool {
+	n = resolve(n)
+	if !d.kind(n, yaml.MappingNode, what) {
+		return false
+	}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := resolve(n.Content[i]), n.Content[i+1]
+		if k.Kind != yaml.ScalarNode {
+			d.fail(k, "invalid field name")
+			continue
+		}
+		if seen[k.Value] {
+			d.fail(k, "field '%s' repeated", k.Value)
+			continue
+		}
+		seen[k.Value] = true
+		if !fn(k.Value, v) {
+			d.fail(k, "unknown field '%s'", k.Value)
+		}
+	}
+	return true
+}
+
+func (d *configDecoder) kind(n *yaml.Node, kind yaml.Kind, what string) bool {
+	if n.Kind == kind {
+		return true
+	}
+	switch kind {
+	case yaml.MappingNode:
+		d.fail(n, "%s must be a mapping", what)
+	case yaml.SequenceNode:
+		d.fail(n, "%s must be a sequence", what)
+	default:
+		d.fail(n, "%s must be a scalar", what)
+	}
+	return false
+}
+
+func (d *configDecoder) int(n *yaml.Node, what string) (int, bool) {
+	n = resolve(n)
+	var v int
+	if n.Kind != yaml.ScalarNode || n.ShortTag() != "!!int" || n.Decode(&v) != nil {
+		d.fail(n, "%s must be an integer", what)
+		return 0, false
+	}
+	return v, true
+}
+
+func (d *configDecoder) string(n *yaml.Node, what string) string {
+	n = resolve(n)
+	if n.Kind != yaml.ScalarNode || n.ShortTag() == "!!null" {
+		d.fail(n, "%s must be a string", what)
+		return ""
+	}
+	return n.Value
+}
+
+// resolve returns the node referred to by an alias.
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim_test
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warthog618/go-gpiosim"
+	"gopkg.in/yaml.v3"
+)
+
+var boardConfig = gpiosim.Config{
+	Name: "board",
+	Banks: []gpiosim.Bank{
+		*gpiosim.NewBank("left", 8,
+			gpiosim.WithNamedLine(3, "LED0"),
+			gpiosim.WithHoggedLine(2, "piggy", gpiosim.HogDirectionOutputLow),
+		),
+		*gpiosim.NewBank("right", 42,
+			gpiosim.WithNamedLine(4, "LED2"),
+			gpiosim.WithHoggedLine(7, "hogster", gpiosim.HogDirectionOutputHigh),
+			gpiosim.WithHoggedLine(9, "piggy", gpiosim.HogDirectionInput),
+		),
+	},
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	p := path.Join(t.TempDir(), name)
+	require.Nil(t, os.WriteFile(p, []byte(content), 0644))
+	return p
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	js, err := json.Marshal(boardConfig)
+	require.Nil(t, err)
+	var jcfg gpiosim.Config
+	require.Nil(t, json.Unmarshal(js, &jcfg))
+	assert.Equal(t, boardConfig, jcfg)
+	lcfg, err := gpiosim.LoadConfig(writeConfig(t, "board.json", string(js)))
+	require.Nil(t, err)
+	assert.Equal(t, boardConfig, lcfg)
+
+	ys, err := yaml.Marshal(boardConfig)
+	require.Nil(t, err)
+	var ycfg gpiosim.Config
+	require.Nil(t, yaml.Unmarshal(ys, &ycfg))
+	assert.Equal(t, boardConfig, ycfg)
+	lcfg, err = gpiosim.LoadConfig(writeConfig(t, "board.yaml", string(ys)))
+	require.Nil(t, err)
+	assert.Equal(t, boardConfig, lcfg)
+
+	// invalid direction
+	_, err = json.Marshal(gpiosim.Hog{"piggy", gpiosim.HogDirection(5)})
+	assert.NotNil(t, err)
+	var h gpiosim.Hog
+	err = json.Unmarshal([]byte(`{"consumer":"piggy","direction":"sideways"}`), &h)
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	yml := `name: board
+banks:
+  - label: left
+    num_lines: 8
+    names:
+      3: LED0
+    hogs:
+      2: {consumer: piggy, direction: output-low}
+  - num_lines: 42
+    label: right
+    names: {4: LED2}
+    hogs:
+      7:
+        consumer: hogster
+        direction: output-high
+      9:
+        consumer: piggy
+`
+	cfg, err := gpiosim.LoadConfig(writeConfig(t, "board.yaml", yml))
+	require.Nil(t, err)
+	assert.Equal(t, boardConfig, cfg)
+
+	js := `{
+	"name": "board",
+	"banks": [
+		{
+			"label": "left",
+			"num_lines": 8,
+			"names": {"3": "LED0"},
+			"hogs": {"2": {"consumer": "piggy", "direction": "output-low"}}
+		},
+		{
+			"label": "right",
+			"num_lines": 42,
+			"names": {"4": "LED2"},
+			"hogs": {
+				"7": {"consumer": "hogster", "direction": "output-high"},
+				"9": {"consumer": "piggy", "direction": "input"}
+			}
+		}
+	]
+}
+`
+	cfg, err = gpiosim.LoadConfig(writeConfig(t, "board.json", js))
+	require.Nil(t, err)
+	assert.Equal(t, boardConfig, cfg)
+
+	_, err = gpiosim.LoadConfig(path.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	patterns := []struct {
+		name     string
+		content  string
+		problems []gpiosim.ConfigProblem
+	}{
+		{
+			"empty",
+			"",
+			[]gpiosim.ConfigProblem{{0, 0, "empty config"}},
+		},
+		{
+			"syntax",
+			"banks:\n  - label: left\n    num_lines: 8: 9\n",
+			[]gpiosim.ConfigProblem{{3, 0, "mapping values are not allowed in this context"}},
+		},
+		{
+			"not a mapping",
+			"- left\n",
+			[]gpiosim.ConfigProblem{{1, 1, "config must be a mapping"}},
+		},
+		{
+			"no banks",
+			"name: board\n",
+			[]gpiosim.ConfigProblem{{1, 1, "missing banks"}},
+		},
+		{
+			"empty banks",
+			"banks: []\n",
+			[]gpiosim.ConfigProblem{{1, 8, "no banks defined"}},
+		},
+		{
+			"bank fields",
+			"banks:\n  - label: left\n    lines: 8\n    label: right\n",
+			[]gpiosim.ConfigProblem{
+				{3, 5, "unknown field 'lines'"},
+				{4, 5, "field 'label' repeated"},
+				{2, 5, "missing num_lines"},
+			},
+		},
+		{
+			"num_lines",
+			"banks:\n  - num_lines: eight\n  - num_lines: 0\n",
+			[]gpiosim.ConfigProblem{
+				{2, 16, "num_lines must be an integer"},
+				{3, 16, "invalid num_lines: 0"},
+			},
+		},
+		{
+			"offsets",
+			"banks:\n  - names: {8: LED0, -1: LED1, x: LED2, 1: LED3, 01: LED4}\n    num_lines: 8\n",
+			[]gpiosim.ConfigProblem{
+				{2, 13, "offset 8 out of range 0..7"},
+				{2, 22, "offset -1 out of range 0..7"},
+				{2, 32, "offset must be an integer"},
+				{2, 50, "offset 1 repeated in names"},
+			},
+		},
+		{
+			"hogs",
+			"banks:\n  - num_lines: 8\n    hogs:\n      1: {consumer: [piggy], direction: sideways}\n      2: piggy\n",
+			[]gpiosim.ConfigProblem{
+				{4, 21, "consumer must be a string"},
+				{4, 41, "invalid hog direction: sideways"},
+				{5, 10, "hog must be a mapping"},
+			},
+		},
+		{
+			"json",
+			"{\n\t\"banks\": [\n\t\t{\"label\": \"left\", \"num_lines\": \"8\"}\n\t]\n}\n",
+			[]gpiosim.ConfigProblem{{3, 34, "num_lines must be an integer"}},
+		},
+	}
+	for _, p := range patterns {
+		tf := func(t *testing.T) {
+			filename := writeConfig(t, "board.yaml", p.content)
+			cfg, err := gpiosim.LoadConfig(filename)
+			assert.ErrorIs(t, err, gpiosim.ErrInvalidConfigFile)
+			assert.Equal(t, gpiosim.Config{}, cfg)
+			var ce gpiosim.ConfigFileError
+			require.ErrorAs(t, err, &ce)
+			assert.Equal(t, filename, ce.File)
+			assert.Equal(t, p.problems, ce.Problems)
+		}
+		t.Run(p.name, tf)
+	}
+}
+
+func TestNewSimFromFile(t *testing.T) {
+	js, err := json.Marshal(boardConfig)
+	require.Nil(t, err)
+	s, err := gpiosim.NewSimFromFile(
+		writeConfig(t, "board.json", string(js)),
+		gpiosim.WithBackend(gpiosim.NewFakeBackend()),
+		gpiosim.WithBank(gpiosim.NewBank("extra", 4)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	assert.Equal(t, "board", s.Name)
+	require.Equal(t, 3, len(s.Chips))
+	assert.Equal(t, boardConfig.Banks[0], s.Chips[0].Config())
+	assert.Equal(t, boardConfig.Banks[1], s.Chips[1].Config())
+	assert.Equal(t, "extra", s.Chips[2].Config().Label)
+	checkChipLevel(t, &s.Chips[1], 7, 1)
+
+	// invalid file
+	bs, err := gpiosim.NewSimFromFile(
+		writeConfig(t, "board.yaml", "banks: []\n"),
+		gpiosim.WithBackend(gpiosim.NewFakeBackend()),
+	)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidConfigFile)
+	assert.Nil(t, bs)
+}
@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Device is a simulated peripheral attached to lines of a sim.
+//
+// The device reacts to the levels of lines driven by the code under test,
+// and drives lines read by the code under test by setting their pulls.
+//
+// The methods are called from a single goroutine, so a device does not need
+// to synchronise access to its own state.
+type Device interface {
+	// Lines returns the names of the lines the device attaches to.
+	Lines() []string
+
+	// Attach is called when the device is attached, with the lines in the same
+	// order as their names are returned by Lines.
+	//
+	// It is called before any changes to the levels of the lines are
+	// reported, so may set the initial pulls of the lines.
+	Attach(lines []Line) error
+
+	// LevelChanged is called for each change to the level of a line.
+	//
+	// The line is one of the lines passed to Attach.
+	LevelChanged(l Line, evt LevelEvent) error
+
+	// Detach is called when the device is detached, after the last change is
+	// reported.
+	Detach() error
+}
+
+// AttachedDevice is a device attached to a sim.
+type AttachedDevice struct {
+	device Device
+
+	// The connections of the sim, from which the device is removed when
+	// closed.
+	connections *connectionSet
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	closeOnce sync.Once
+}
+
+// AttachDevice attaches the device to the lines of the sim with the names
+// returned by the device, and runs the device until it is closed, the sim is
+// closed, or the device returns an error.
+//
+// Returns a LineNotFoundError or AmbiguousLineError if a name does not
+// identify exactly one line in the sim, or the error returned by
+// Device.Attach.
+//
+// The levels of the lines are watched as per Chip.WatchLevel, and each
+// change is passed to Device.LevelChanged.
+// If the device returns an error then it is detached, and the error is
+// available from the AttachedDevice.
+func (s *Sim) AttachDevice(d Device) (*AttachedDevice, error) {
+	names := d.Lines()
+	lines := make([]Line, len(names))
+	offsets := make(map[*Chip][]int)
+	var chips []*Chip
+	for i, name := range names {
+		l, err := s.FindLine(name)
+		if err != nil {
+			return nil, err
+		}
+		lines[i] = l
+		if _, ok := offsets[l.chip]; !ok {
+			chips = append(chips, l.chip)
+		}
+		offsets[l.chip] = append(offsets[l.chip], l.offset)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	type change struct {
+		chip *Chip
+		evt  LevelEvent
+	}
+	changes := make(chan change)
+	failures := make(chan error, len(chips))
+	var wg sync.WaitGroup
+	for _, c := range chips {
+		events, err := c.WatchLevel(ctx, offsets[c]...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		wg.Add(1)
+		go func(c *Chip) {
+			defer wg.Done()
+			for evt := range events {
+				select {
+				case changes <- change{c, evt}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if ctx.Err() == nil {
+				failures <- errors.Errorf("failed to read levels of lines on %s", c.chipName)
+			}
+		}(c)
+	}
+	if err := d.Attach(lines); err != nil {
+		cancel()
+		wg.Wait()
+		return nil, err
+	}
+	ad := AttachedDevice{
+		device:      d,
+		connections: s.connections,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	go func() {
+		defer close(ad.done)
+		var err error
+	loop:
+		for {
+			select {
+			case ch := <-changes:
+				l, _ := ch.chip.Line(ch.evt.Offset)
+				if err = d.LevelChanged(l, ch.evt); err != nil {
+					break loop
+				}
+			case err = <-failures:
+				break loop
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		cancel()
+		wg.Wait()
+		if derr := d.Detach(); err == nil {
+			err = derr
+		}
+		ad.err = err
+	}()
+	s.connections.add(&ad)
+	return &ad, nil
+}
+
+// Device returns the attached device.
+func (a *AttachedDevice) Device() Device {
+	return a.device
+}
+
+// Done returns a channel that is closed when the device is detached.
+func (a *AttachedDevice) Done() <-chan struct{} {
+	return a.done
+}
+
+// Err returns the error returned by the device, once it has been detached,
+// or nil if the device returned no error or is still attached.
+func (a *AttachedDevice) Err() error {
+	select {
+	case <-a.done:
+		return a.err
+	default:
+		return nil
+	}
+}
+
+// Close detaches the device, and returns any error returned by the device.
+func (a *AttachedDevice) Close() error {
+	a.closeOnce.Do(func() {
+		a.cancel()
+		a.connections.remove(a)
+	})
+	<-a.done
+	return a.err
+}
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// The default period NewSim waits for a gpiochip device node to be created.
+const defaultDevNodeTimeout = time.Second
+
+// waitDevNode waits for the device node for the chip to exist, and to match
+// the device number of the chip reported by sysfs.
+//
+// Device nodes may be created asynchronously, e.g. by udev or mdev, after the
+// sim goes live, so the directory containing the node is watched until the
+// node appears or the timeout expires.
+func (k *kernelBackend) waitDevNode(c *Chip) error {
+	dev, err := k.readAttr(c.sysfsPath, "dev")
+	if err != nil {
+		return err
+	}
+	var major, minor uint32
+	if _, err := fmt.Sscanf(dev, "%d:%d", &major, &minor); err != nil {
+		return errors.Errorf("unexpected dev value: %s", dev)
+	}
+	want := unix.Mkdev(major, minor)
+
+	w := newDirWatcher(path.Dir(c.devPath))
+	defer w.close()
+	timeout := k.devNodeTimeout
+	if timeout == 0 {
+		timeout = defaultDevNodeTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		stat, err := k.fs.lstat(c.devPath)
+		switch {
+		case err == nil:
+			if stat.Mode()&fs.ModeSymlink != 0 {
+				return SymlinkMaskingError{c.devPath, c.chipName}
+			}
+			got, ok := deviceNumber(stat)
+			if ok && got == want {
+				return nil
+			}
+			err = DevNodeMismatchError{c.devPath, dev, deviceString(stat)}
+		case errors.Is(err, fs.ErrNotExist):
+			err = DevNodeTimeoutError{c.devPath, timeout}
+		default:
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
+		}
+		w.wait(remaining)
+	}
+}
+
+// deviceNumber returns the device number of a character device.
+//
+// Returns false if the file is not a character device.
+func deviceNumber(stat fs.FileInfo) (uint64, bool) {
+	if stat.Mode()&fs.ModeCharDevice == 0 {
+		return 0, false
+	}
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Rdev), true
+}
+
+// deviceString describes the file as a device, in the form used by sysfs.
+func deviceString(stat fs.FileInfo) string {
+	dev, ok := deviceNumber(stat)
+	if !ok {
+		return "not a character device"
+	}
+	return fmt.Sprintf("%d:%d", unix.Major(dev), unix.Minor(dev))
+}
+
+// dirWatcher waits for changes to the entries in a directory using inotify.
+//
+// If inotify is not available then the watcher falls back to polling.
+type dirWatcher struct {
+	fd int
+}
+
+// The period between checks when polling a directory for changes.
+const dirPollInterval = 10 * time.Millisecond
+
+func newDirWatcher(dir string) dirWatcher {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return dirWatcher{-1}
+	}
+	mask := uint32(unix.IN_CREATE | unix.IN_ATTRIB | unix.IN_MOVED_TO | unix.IN_DELETE)
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		unix.Close(fd)
+		return dirWatcher{-1}
+	}
+	return dirWatcher{fd}
+}
+
+// wait waits for a change to the directory, or for the timeout to expire.
+//
+// Spurious wakeups are possible, so the caller must recheck the directory.
+func (w dirWatcher) wait(timeout time.Duration) {
+	if w.fd < 0 {
+		if timeout > dirPollInterval {
+			timeout = dirPollInterval
+		}
+		time.Sleep(timeout)
+		return
+	}
+	// round up so a sub-millisecond timeout does not spin
+	ms := int((timeout + time.Millisecond - 1) / time.Millisecond)
+	pfd := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+	if n, err := unix.Poll(pfd, ms); err != nil || n == 0 {
+		return
+	}
+	// drain the events - their content is irrelevant
+	buf := make([]byte, 4096)
+	for {
+		if n, err := unix.Read(w.fd, buf); n <= 0 || err != nil {
+			return
+		}
+	}
+}
+
+func (w dirWatcher) close() {
+	if w.fd >= 0 {
+		unix.Close(w.fd)
+	}
+}
@@ -29,6 +29,10 @@ configuration and the corresponding gpiochips.
 Configuring a simulator involves configfs, and manipulating the chips once live
 involves sysfs, so root permissions are typically required to run a simulator.
 
+Tests that only require the [Chip] interface, and not access to the gpiochips
+via the GPIO uAPI, may use the [FakeBackend], which simulates the chips in
+memory and so requires neither root permissions nor gpio-sim.
+
 # Example Usage
 
 Create a [Simpleton] with 12 lines:
@@ -0,0 +1,506 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned by NewSim and Chip methods.
+//
+// ErrModuleNotLoaded, ErrConfigfsNotMounted, ErrPermissionDenied,
+// ErrSymlinkMasking, ErrDevNodeTimeout and ErrDevNodeMismatch indicate a
+// problem with the environment, rather than with the configuration of the sim
+// or how it is being used.
+var (
+	// ErrModuleNotLoaded indicates that gpio-sim is not available in configfs,
+	// and could not be loaded.
+	ErrModuleNotLoaded = errors.New("gpio-sim module not loaded")
+
+	// ErrConfigfsNotMounted indicates that configfs is not mounted, and could
+	// not be mounted.
+	ErrConfigfsNotMounted = errors.New("can't find configfs mountpoint")
+
+	// ErrPermissionDenied indicates that the caller does not have permission
+	// to access configfs or sysfs.
+	//
+	// This is the same value as fs.ErrPermission, so it matches the errors
+	// returned by the file system.
+	ErrPermissionDenied = fs.ErrPermission
+
+	// ErrSymlinkMasking indicates that a symlink is masking a gpiochip device.
+	//
+	// The full details are provided by a SymlinkMaskingError.
+	ErrSymlinkMasking = errors.New("symlink is masking GPIO device")
+
+	// ErrNoBanks indicates that NewSim was called without any banks.
+	ErrNoBanks = errors.New("no banks defined")
+
+	// ErrSimExists indicates that a sim with the requested name already exists.
+	//
+	// The full details are provided by a SimExistsError.
+	ErrSimExists = errors.New("sim already exists")
+
+	// ErrSimNotFound indicates that the sim to be opened does not exist.
+	//
+	// The full details are provided by a SimNotFoundError.
+	ErrSimNotFound = errors.New("sim not found")
+
+	// ErrInvalidOffset indicates that a line offset is outside the range of
+	// the chip.
+	//
+	// The full details are provided by an InvalidOffsetError.
+	ErrInvalidOffset = errors.New("invalid offset")
+
+	// ErrLineNotFound indicates that no line has the requested name.
+	//
+	// The full details are provided by a LineNotFoundError.
+	ErrLineNotFound = errors.New("line not found")
+
+	// ErrAmbiguousLine indicates that more than one line has the requested
+	// name.
+	//
+	// The full details are provided by an AmbiguousLineError.
+	ErrAmbiguousLine = errors.New("ambiguous line name")
+
+	// ErrInvalidStep indicates that a step of a waveform is invalid.
+	//
+	// The full details are provided by a StepError.
+	ErrInvalidStep = errors.New("invalid step")
+
+	// ErrInvalidTrace indicates that a trace could not be parsed.
+	//
+	// The full details are provided by a TraceError.
+	ErrInvalidTrace = errors.New("invalid trace")
+
+	// ErrInvalidBank indicates that the configuration of a bank is invalid.
+	//
+	// The full details are provided by a BankError, or by a ConfigError if
+	// returned by NewSim.
+	ErrInvalidBank = errors.New("invalid bank")
+
+	// ErrInvalidNumLines indicates that the number of lines in a bank is not
+	// positive.
+	//
+	// The full details are provided by a NumLinesError.
+	ErrInvalidNumLines = errors.New("invalid num_lines")
+
+	// ErrInvalidConfigFile indicates that a sim configuration file is invalid.
+	//
+	// The full details are provided by a ConfigFileError.
+	ErrInvalidConfigFile = errors.New("invalid config file")
+
+	// ErrBusy indicates that the sim could not be removed as it is in use.
+	//
+	// The full details are provided by a TeardownError.
+	ErrBusy = errors.New("sim busy")
+
+	// ErrDevNodeTimeout indicates that the device node for a gpiochip was not
+	// created within the timeout.
+	//
+	// The full details are provided by a DevNodeTimeoutError.
+	ErrDevNodeTimeout = errors.New("timeout waiting for GPIO device node")
+
+	// ErrDevNodeMismatch indicates that the device node for a gpiochip does
+	// not match the device number of the gpiochip.
+	//
+	// The full details are provided by a DevNodeMismatchError.
+	ErrDevNodeMismatch = errors.New("GPIO device node mismatch")
+)
+
+// SimExistsError indicates that a sim with the requested name already exists.
+type SimExistsError struct {
+	// The name of the sim.
+	Name string
+}
+
+func (e SimExistsError) Error() string {
+	return fmt.Sprintf("sim with name '%s' already exists", e.Name)
+}
+
+// Is returns true if the target is ErrSimExists.
+func (e SimExistsError) Is(target error) bool {
+	return target == ErrSimExists
+}
+
+// SimNotFoundError indicates that the sim to be opened does not exist.
+type SimNotFoundError struct {
+	// The name of the sim.
+	Name string
+}
+
+func (e SimNotFoundError) Error() string {
+	return fmt.Sprintf("sim with name '%s' not found", e.Name)
+}
+
+// Is returns true if the target is ErrSimNotFound.
+func (e SimNotFoundError) Is(target error) bool {
+	return target == ErrSimNotFound
+}
+
+// SymlinkMaskingError indicates that a symlink is masking a gpiochip device.
+type SymlinkMaskingError struct {
+	// The path to the symlink.
+	Path string
+
+	// The name of the gpiochip being masked.
+	ChipName string
+}
+
+func (e SymlinkMaskingError) Error() string {
+	return "A symlink (" + e.Path + ") is masking GPIO device " + e.ChipName
+}
+
+// Is returns true if the target is ErrSymlinkMasking.
+func (e SymlinkMaskingError) Is(target error) bool {
+	return target == ErrSymlinkMasking
+}
+
+// DevNodeTimeoutError indicates that the device node for a gpiochip was not
+// created within the timeout.
+type DevNodeTimeoutError struct {
+	// The path to the device node.
+	Path string
+
+	// The period waited for the device node.
+	Timeout time.Duration
+}
+
+func (e DevNodeTimeoutError) Error() string {
+	return fmt.Sprintf("GPIO device %s not created within %s", e.Path, e.Timeout)
+}
+
+// Is returns true if the target is ErrDevNodeTimeout.
+func (e DevNodeTimeoutError) Is(target error) bool {
+	return target == ErrDevNodeTimeout
+}
+
+// DevNodeMismatchError indicates that the device node for a gpiochip does not
+// match the device number of the gpiochip.
+type DevNodeMismatchError struct {
+	// The path to the device node.
+	Path string
+
+	// The device number of the gpiochip, as reported by sysfs.
+	Want string
+
+	// The device number of the device node.
+	Got string
+}
+
+func (e DevNodeMismatchError) Error() string {
+	return fmt.Sprintf("GPIO device %s is %s, expected %s", e.Path, e.Got, e.Want)
+}
+
+// Is returns true if the target is ErrDevNodeMismatch.
+func (e DevNodeMismatchError) Is(target error) bool {
+	return target == ErrDevNodeMismatch
+}
+
+// InvalidOffsetError indicates that a line offset is outside the range of
+// the chip.
+type InvalidOffsetError struct {
+	// The offset of the line.
+	Offset int
+
+	// The number of lines on the chip.
+	NumLines int
+}
+
+func (e InvalidOffsetError) Error() string {
+	return fmt.Sprintf("offset %d out of range 0..%d", e.Offset, e.NumLines-1)
+}
+
+// Is returns true if the target is ErrInvalidOffset.
+func (e InvalidOffsetError) Is(target error) bool {
+	return target == ErrInvalidOffset
+}
+
+// StepError indicates that a step of a waveform is invalid.
+type StepError struct {
+	// The index of the step in the waveform.
+	Index int
+
+	// A description of the problem.
+	Reason string
+}
+
+func (e StepError) Error() string {
+	return fmt.Sprintf("step %d: %s", e.Index, e.Reason)
+}
+
+// Is returns true if the target is ErrInvalidStep.
+func (e StepError) Is(target error) bool {
+	return target == ErrInvalidStep
+}
+
+// TraceError indicates a problem parsing a trace.
+type TraceError struct {
+	// The name of the file containing the trace, if loaded from a file.
+	File string
+
+	// The line of the trace containing the problem.
+	Line int
+
+	// A description of the problem.
+	Reason string
+}
+
+func (e TraceError) Error() string {
+	if len(e.File) == 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Reason)
+}
+
+// Is returns true if the target is ErrInvalidTrace.
+func (e TraceError) Is(target error) bool {
+	return target == ErrInvalidTrace
+}
+
+// LineNotFoundError indicates that no line has the requested name.
+type LineNotFoundError struct {
+	// The name of the line.
+	Name string
+}
+
+func (e LineNotFoundError) Error() string {
+	return fmt.Sprintf("line '%s' not found", e.Name)
+}
+
+// Is returns true if the target is ErrLineNotFound.
+func (e LineNotFoundError) Is(target error) bool {
+	return target == ErrLineNotFound
+}
+
+// AmbiguousLineError indicates that more than one line has the requested
+// name.
+type AmbiguousLineError struct {
+	// The name of the line.
+	Name string
+
+	// The lines with the name.
+	Lines []Line
+}
+
+func (e AmbiguousLineError) Error() string {
+	lines := make([]string, len(e.Lines))
+	for i, l := range e.Lines {
+		lines[i] = fmt.Sprintf("%s:%d", l.chip.ChipName(), l.offset)
+	}
+	return fmt.Sprintf("line '%s' is ambiguous: %s", e.Name, strings.Join(lines, ", "))
+}
+
+// Is returns true if the target is ErrAmbiguousLine.
+func (e AmbiguousLineError) Is(target error) bool {
+	return target == ErrAmbiguousLine
+}
+
+// LineConfigError indicates a problem with the configuration of a line in a
+// Bank.
+type LineConfigError struct {
+	// The offset of the line.
+	Offset int
+
+	// A description of the problem.
+	Reason string
+
+	// The sentinel error identifying the problem, if any, such as
+	// ErrInvalidOffset for an offset out of range.
+	Err error
+}
+
+func (e LineConfigError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Offset, e.Reason)
+}
+
+// Is returns true if the target is the sentinel error identifying the
+// problem.
+func (e LineConfigError) Is(target error) bool {
+	return e.Err != nil && target == e.Err
+}
+
+// NumLinesError indicates that the number of lines in a Bank is not positive.
+type NumLinesError struct {
+	// The number of lines.
+	NumLines int
+}
+
+func (e NumLinesError) Error() string {
+	return fmt.Sprintf("invalid num_lines: %d", e.NumLines)
+}
+
+// Is returns true if the target is ErrInvalidNumLines.
+func (e NumLinesError) Is(target error) bool {
+	return target == ErrInvalidNumLines
+}
+
+// BankError indicates one or more problems with the configuration of a Bank.
+type BankError struct {
+	// The label of the bank.
+	Label string
+
+	// The problems found with the bank.
+	//
+	// Problems specific to a line are LineConfigErrors, and a problem with the
+	// number of lines is a NumLinesError.
+	Problems []error
+}
+
+func (e BankError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		problems[i] = p.Error()
+	}
+	return fmt.Sprintf("bank '%s': %s", e.Label, strings.Join(problems, ", "))
+}
+
+// Is returns true if the target is ErrInvalidBank, or matches any of the
+// problems.
+func (e BankError) Is(target error) bool {
+	if target == ErrInvalidBank {
+		return true
+	}
+	for _, p := range e.Problems {
+		if errors.Is(p, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigError indicates one or more invalid banks were provided to NewSim.
+type ConfigError struct {
+	// The errors for the invalid banks, keyed by the index of the bank.
+	Banks map[int]BankError
+}
+
+func (e ConfigError) Error() string {
+	banks := make([]string, 0, len(e.Banks))
+	for _, i := range sortedOffsets(e.Banks) {
+		banks = append(banks, fmt.Sprintf("bank%d: %s", i, e.Banks[i]))
+	}
+	return strings.Join(banks, "; ")
+}
+
+// Is returns true if the target is ErrInvalidBank, or matches any of the
+// bank errors.
+func (e ConfigError) Is(target error) bool {
+	if target == ErrInvalidBank {
+		return true
+	}
+	for _, b := range e.Banks {
+		if b.Is(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigFileError indicates one or more problems with a sim configuration
+// file.
+type ConfigFileError struct {
+	// The name of the file.
+	File string
+
+	// The problems found with the file, in the order found.
+	Problems []ConfigProblem
+}
+
+func (e ConfigFileError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		problems[i] = p.location(e.File) + ": " + p.Reason
+	}
+	return strings.Join(problems, "; ")
+}
+
+// Is returns true if the target is ErrInvalidConfigFile.
+func (e ConfigFileError) Is(target error) bool {
+	return target == ErrInvalidConfigFile
+}
+
+// ConfigProblem describes a problem found in a sim configuration file, and
+// where it was found.
+type ConfigProblem struct {
+	// The line, starting from 1, or 0 if unknown.
+	Line int
+
+	// The column, starting from 1, or 0 if unknown.
+	Column int
+
+	// A description of the problem.
+	Reason string
+}
+
+// location returns the location of the problem in the form file:line:column,
+// omitting any unknown parts.
+func (p ConfigProblem) location(file string) string {
+	switch {
+	case p.Line == 0:
+		return file
+	case p.Column == 0:
+		return fmt.Sprintf("%s:%d", file, p.Line)
+	default:
+		return fmt.Sprintf("%s:%d:%d", file, p.Line, p.Column)
+	}
+}
+
+// TeardownError indicates that a sim could not be fully removed.
+type TeardownError struct {
+	// The failures due to the sim being busy.
+	//
+	// These are typically transient, and removal may succeed if retried.
+	Busy []error
+
+	// The failures for any other reason.
+	Other []error
+}
+
+func (e TeardownError) Error() string {
+	errs := make([]string, 0, len(e.Busy)+len(e.Other))
+	for _, err := range append(append([]error{}, e.Busy...), e.Other...) {
+		errs = append(errs, err.Error())
+	}
+	return "teardown failed: " + strings.Join(errs, ", ")
+}
+
+// Is returns true if the target is ErrBusy and any of the failures were due
+// to the sim being busy, or if the target matches any of the failures.
+func (e TeardownError) Is(target error) bool {
+	if target == ErrBusy && len(e.Busy) != 0 {
+		return true
+	}
+	for _, err := range e.Busy {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	for _, err := range e.Other {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// add records the error, if any, as either a Busy or Other failure.
+//
+// Failures due to the part being removed not existing are ignored.
+func (e *TeardownError) add(err error) {
+	switch {
+	case err == nil, errors.Is(err, fs.ErrNotExist):
+	case errors.Is(err, syscall.EBUSY):
+		e.Busy = append(e.Busy, err)
+	default:
+		e.Other = append(e.Other, err)
+	}
+}
@@ -0,0 +1,316 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// FakeBackend provides sims that are simulated entirely in memory.
+//
+// The fake models the pulls, output levels and hogs of the simulated lines,
+// as exposed via Chip, without requiring root permissions or gpio-sim.
+//
+// No gpiochips are created, so the chips cannot be accessed via the GPIO uAPI
+// and the DevPath of the chips is empty.
+// Instead, userspace requesting a line as an output is emulated using Drive
+// and Release.
+//
+// Once a sim is closed, accessing the lines of its chips fails, as it would
+// for a gpio-sim.
+//
+// A FakeBackend may provide several sims, in which case the names of the sims
+// must be unique within the backend.
+type FakeBackend struct {
+	mu sync.Mutex
+
+	// The names of the live sims.
+	sims map[string]bool
+
+	// Counters used to generate unique device and chip names.
+	devCount  int
+	chipCount int
+}
+
+// NewFakeBackend creates a backend that simulates chips in memory.
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{sims: make(map[string]bool)}
+}
+
+// Drive emulates userspace requesting the line as an output and driving it
+// to the given level.
+//
+// The line remains an output until Release is called.
+func (f *FakeBackend) Drive(c *Chip, offset, level int) error {
+	fc, err := f.chip(c)
+	if err != nil {
+		return err
+	}
+	return fc.drive(offset, level)
+}
+
+// Release emulates userspace releasing a line previously driven with Drive.
+func (f *FakeBackend) Release(c *Chip, offset int) error {
+	fc, err := f.chip(c)
+	if err != nil {
+		return err
+	}
+	return fc.release(offset)
+}
+
+// chip returns the fake state underlying the chip.
+func (f *FakeBackend) chip(c *Chip) (*fakeChip, error) {
+	fc, ok := c.lines.(*fakeChip)
+	if !ok || fc.backend != f {
+		return nil, errors.Errorf("chip %s is not provided by the fake backend", c.chipName)
+	}
+	return fc, nil
+}
+
+// live creates the fake chips for the sim.
+func (f *FakeBackend) live(s *Sim) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.sims[s.Name] {
+		return SimExistsError{s.Name}
+	}
+	devName := fmt.Sprintf("gpio-sim.%d", f.devCount)
+	f.devCount++
+	for i := range s.Chips {
+		c := &s.Chips[i]
+		c.devName = devName
+		c.chipName = fmt.Sprintf("gpiochip%d", f.chipCount)
+		f.chipCount++
+		c.lines = newFakeChip(f, path.Join(devName, c.chipName), c.cfg)
+	}
+	f.sims[s.Name] = true
+	return nil
+}
+
+// close removes the sim from the backend.
+//
+// The chips of the sim are marked as removed, so subsequent accesses to their
+// lines fail, as they would for a removed gpio-sim.
+func (f *FakeBackend) close(s *Sim) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range s.Chips {
+		if fc, ok := s.Chips[i].lines.(*fakeChip); ok {
+			fc.remove()
+		}
+	}
+	delete(f.sims, s.Name)
+	return nil
+}
+
+// fakeChip contains the state of the lines of a fake chip.
+type fakeChip struct {
+	mu sync.Mutex
+
+	// The backend providing the chip.
+	backend *FakeBackend
+
+	// The path of the chip, as used in errors.
+	path string
+
+	lines []fakeLine
+
+	// True once the sim containing the chip has been closed.
+	removed bool
+}
+
+// fakeLine contains the state of a fake line.
+type fakeLine struct {
+	// The pull applied to the line.
+	pull int
+
+	// The level the line is driven to, if an output.
+	level int
+
+	// True if the line is requested as an output.
+	output bool
+
+	// True if the line is hogged.
+	hogged bool
+}
+
+func newFakeChip(f *FakeBackend, p string, cfg Bank) *fakeChip {
+	fc := fakeChip{backend: f, path: p, lines: make([]fakeLine, cfg.NumLines)}
+	for o, h := range cfg.Hogs {
+		if o < 0 || o >= cfg.NumLines {
+			continue
+		}
+		l := &fc.lines[o]
+		l.hogged = true
+		switch h.Direction {
+		case HogDirectionOutputLow:
+			l.output = true
+		case HogDirectionOutputHigh:
+			l.output = true
+			l.level = LevelActive
+		}
+	}
+	return &fc
+}
+
+func (fc *fakeChip) drive(offset, level int) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	l, err := fc.state(offset)
+	if err != nil {
+		return err
+	}
+	if l.hogged {
+		return syscall.EBUSY
+	}
+	l.output = true
+	l.level = LevelInactive
+	if level == LevelActive {
+		l.level = LevelActive
+	}
+	return nil
+}
+
+func (fc *fakeChip) release(offset int) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	l, err := fc.state(offset)
+	if err != nil {
+		return err
+	}
+	if l.hogged {
+		return syscall.EBUSY
+	}
+	l.output = false
+	return nil
+}
+
+// remove marks the chip as removed.
+func (fc *fakeChip) remove() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.removed = true
+}
+
+// state returns the state of the line at offset.
+//
+// Fails if the chip has been removed.
+func (fc *fakeChip) state(offset int) (*fakeLine, error) {
+	if fc.removed {
+		return nil, &fs.PathError{Op: "open", Path: fc.path, Err: fs.ErrNotExist}
+	}
+	if offset < 0 || offset >= len(fc.lines) {
+		return nil, InvalidOffsetError{offset, len(fc.lines)}
+	}
+	return &fc.lines[offset], nil
+}
+
+// line returns the attributes of the fake line.
+func (fc *fakeChip) line(offset int) lineAttr {
+	return fakeLineAttr{fc, offset}
+}
+
+// lineDrive returns how the line at offset is driven.
+//
+// Fake outputs are push-pull, as open-drain is emulated by Release.
+func (fc *fakeChip) lineDrive(offset int) (int, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	l, err := fc.state(offset)
+	if err != nil {
+		return driveNone, err
+	}
+	if l.output {
+		return drivePushPull, nil
+	}
+	return driveNone, nil
+}
+
+// close is a no-op, as the drive of fake lines is read from memory.
+func (fc *fakeChip) close() {}
+
+// fakeLineAttr provides access to the attributes of a fake line.
+type fakeLineAttr struct {
+	chip   *fakeChip
+	offset int
+}
+
+func (l fakeLineAttr) readAttr(attr string) (string, error) {
+	return l.chip.readAttr(l.offset, attr)
+}
+
+func (l fakeLineAttr) writeAttr(attr, value string) error {
+	return l.chip.writeAttr(l.offset, attr, value)
+}
+
+func (fc *fakeChip) pathError(op string, offset int, attr string, err error) error {
+	return &fs.PathError{
+		Op:   op,
+		Path: path.Join(fc.path, fmt.Sprintf("sim_gpio%d", offset), attr),
+		Err:  err,
+	}
+}
+
+func (fc *fakeChip) readAttr(offset int, attr string) (string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	l, err := fc.state(offset)
+	if err != nil {
+		return "", err
+	}
+	switch attr {
+	case "pull":
+		if l.pull == LevelActive {
+			return "pull-up", nil
+		}
+		return "pull-down", nil
+	case "value":
+		v := l.pull
+		if l.output {
+			v = l.level
+		}
+		return fmt.Sprintf("%d", v), nil
+	}
+	return "", fc.pathError("open", offset, attr, fs.ErrNotExist)
+}
+
+func (fc *fakeChip) writeAttr(offset int, attr, value string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	l, err := fc.state(offset)
+	if err != nil {
+		return err
+	}
+	switch attr {
+	case "pull":
+		switch value {
+		case "pull-up":
+			l.pull = LevelActive
+		case "pull-down":
+			l.pull = LevelInactive
+		default:
+			return fc.pathError("write", offset, attr, syscall.EINVAL)
+		}
+		return nil
+	case "value":
+		// value is read-only in gpio-sim
+		return fc.pathError("open", offset, attr, fs.ErrPermission)
+	}
+	return fc.pathError("open", offset, attr, fs.ErrNotExist)
+}
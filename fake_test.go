@@ -0,0 +1,1032 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warthog618/go-gpiosim"
+)
+
+func TestFakeNewSim(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithName("gpiosim_test"),
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8,
+			gpiosim.WithNamedLine(3, "LED0"),
+			gpiosim.WithHoggedLine(2, "piggy", gpiosim.HogDirectionOutputLow),
+		)),
+		gpiosim.WithBank(gpiosim.NewBank("right", 42,
+			gpiosim.WithHoggedLine(7, "hogster", gpiosim.HogDirectionOutputHigh),
+			gpiosim.WithHoggedLine(9, "piggy", gpiosim.HogDirectionInput),
+		)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	assert.Equal(t, "gpiosim_test", s.Name)
+	require.Equal(t, 2, len(s.Chips))
+	assert.Equal(t, 8, s.Chips[0].Config().NumLines)
+	assert.Equal(t, 42, s.Chips[1].Config().NumLines)
+	assert.NotEqual(t, s.Chips[0].ChipName(), s.Chips[1].ChipName())
+	assert.Empty(t, s.Chips[0].DevPath())
+
+	// hogs
+	checkChipLevel(t, &s.Chips[0], 2, 0)
+	checkChipLevel(t, &s.Chips[1], 7, 1)
+	checkChipLevel(t, &s.Chips[1], 9, 0)
+	err = s.Chips[1].Pullup(9)
+	assert.Nil(t, err)
+	checkChipLevel(t, &s.Chips[1], 9, 1)
+	err = fb.Drive(&s.Chips[1], 9, 0)
+	assert.ErrorIs(t, err, syscall.EBUSY)
+
+	// non-unique name
+	bs, err := gpiosim.NewSim(
+		gpiosim.WithName("gpiosim_test"),
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8)),
+	)
+	assert.ErrorIs(t, err, gpiosim.ErrSimExists)
+	assert.Nil(t, bs)
+
+	// no banks
+	bs, err = gpiosim.NewSim(gpiosim.WithBackend(fb))
+	assert.ErrorIs(t, err, gpiosim.ErrNoBanks)
+	assert.Nil(t, bs)
+
+	// name is available once closed
+	s.Close()
+	bs, err = gpiosim.NewSim(
+		gpiosim.WithName("gpiosim_test"),
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8)),
+	)
+	assert.Nil(t, err)
+	require.NotNil(t, bs)
+	bs.Close()
+}
+
+func TestFakeChipPull(t *testing.T) {
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(gpiosim.NewFakeBackend()),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	offset := 3
+	c := &s.Chips[0]
+	checkChipPull(t, c, offset, 0)
+	checkChipLevel(t, c, offset, 0)
+
+	err = c.SetPull(offset, 1)
+	assert.Nil(t, err)
+	checkChipPull(t, c, offset, 1)
+	checkChipLevel(t, c, offset, 1)
+
+	err = c.Pulldown(offset)
+	assert.Nil(t, err)
+	checkChipPull(t, c, offset, 0)
+	checkChipLevel(t, c, offset, 0)
+
+	err = c.Toggle(offset)
+	assert.Nil(t, err)
+	checkChipPull(t, c, offset, 1)
+	checkChipLevel(t, c, offset, 1)
+
+	// out of range
+	err = c.Pullup(8)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidOffset)
+	_, err = c.Pull(-1)
+	var oe gpiosim.InvalidOffsetError
+	require.ErrorAs(t, err, &oe)
+	assert.Equal(t, -1, oe.Offset)
+	assert.Equal(t, 8, oe.NumLines)
+}
+
+func TestFakeChipLevel(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSimpleton(8, gpiosim.WithBackend(fb))
+	require.Nil(t, err)
+	defer s.Close()
+
+	offset := 3
+	c := &s.Chips[0]
+	err = fb.Drive(c, offset, 1)
+	assert.Nil(t, err)
+	checkSimpletonLevel(t, s, offset, 1)
+	checkSimpletonPull(t, s, offset, 0) // driven level does not effect pull
+
+	err = fb.Drive(c, offset, 0)
+	assert.Nil(t, err)
+	err = s.Pullup(offset)
+	assert.Nil(t, err)
+	checkSimpletonLevel(t, s, offset, 0)
+	checkSimpletonPull(t, s, offset, 1)
+
+	// released line reverts to following the pull
+	err = fb.Release(c, offset)
+	assert.Nil(t, err)
+	checkSimpletonLevel(t, s, offset, 1)
+
+	l, err := s.Line(offset)
+	assert.Nil(t, err)
+	checkLine(t, l, c, offset)
+
+	// chip from another backend
+	err = gpiosim.NewFakeBackend().Drive(c, offset, 1)
+	assert.NotNil(t, err)
+}
+
+func TestFakeFindLine(t *testing.T) {
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(gpiosim.NewFakeBackend()),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8,
+			gpiosim.WithNamedLine(3, "LED0"),
+			gpiosim.WithNamedLine(5, "BUTTON"),
+			gpiosim.WithNamedLine(1, "BUTTON"),
+		)),
+		gpiosim.WithBank(gpiosim.NewBank("right", 42,
+			gpiosim.WithNamedLine(4, "LED1"),
+			gpiosim.WithNamedLine(7, "LED0"),
+		)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	left := &s.Chips[0]
+	right := &s.Chips[1]
+
+	// chip
+	l, err := left.FindLine("LED0")
+	assert.Nil(t, err)
+	checkLine(t, l, left, 3)
+	_, err = left.FindLine("LED1")
+	assert.ErrorIs(t, err, gpiosim.ErrLineNotFound)
+	_, err = left.FindLine("BUTTON")
+	assert.ErrorIs(t, err, gpiosim.ErrAmbiguousLine)
+	lines := left.FindLines("BUTTON")
+	require.Equal(t, 2, len(lines))
+	checkLine(t, lines[0], left, 1)
+	checkLine(t, lines[1], left, 5)
+	assert.Empty(t, right.FindLines("BUTTON"))
+
+	// sim
+	l, err = s.FindLine("LED1")
+	assert.Nil(t, err)
+	checkLine(t, l, right, 4)
+	_, err = s.FindLine("BUTTON2")
+	assert.ErrorIs(t, err, gpiosim.ErrLineNotFound)
+	_, err = s.FindLine("LED0")
+	var ae gpiosim.AmbiguousLineError
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, "LED0", ae.Name)
+	require.Equal(t, 2, len(ae.Lines))
+	checkLine(t, ae.Lines[0], left, 3)
+	checkLine(t, ae.Lines[1], right, 7)
+	assert.Equal(t, ae.Lines, s.FindLines("LED0"))
+	assert.Equal(t, 2, len(s.FindLines("BUTTON")))
+	assert.Empty(t, s.FindLines("BUTTON2"))
+}
+
+func TestFakeLine(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8,
+			gpiosim.WithNamedLine(3, "LED0"),
+			gpiosim.WithHoggedLine(2, "piggy", gpiosim.HogDirectionOutputHigh),
+		)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	c := &s.Chips[0]
+	l, err := c.Line(3)
+	require.Nil(t, err)
+	checkLine(t, l, c, 3)
+	assert.Equal(t, "LED0", l.Name())
+	_, ok := l.Hog()
+	assert.False(t, ok)
+
+	// pulls
+	checkLinePull(t, l, 0)
+	assert.Nil(t, l.Pullup())
+	checkLinePull(t, l, 1)
+	checkChipPull(t, c, 3, 1)
+	assert.Nil(t, l.Toggle())
+	checkLinePull(t, l, 0)
+	assert.Nil(t, l.SetPull(1))
+	checkLinePull(t, l, 1)
+	assert.Nil(t, l.Pulldown())
+	checkLinePull(t, l, 0)
+
+	// levels
+	v, err := l.Level()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, v)
+	assert.Nil(t, fb.Drive(c, 3, 1))
+	v, err = l.Level()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, v)
+
+	// hog
+	l, err = c.Line(2)
+	require.Nil(t, err)
+	assert.Empty(t, l.Name())
+	h, ok := l.Hog()
+	assert.True(t, ok)
+	assert.Equal(t, gpiosim.Hog{"piggy", gpiosim.HogDirectionOutputHigh}, h)
+	v, err = l.Level()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, v)
+
+	// out of range
+	_, err = c.Line(8)
+	var oe gpiosim.InvalidOffsetError
+	require.ErrorAs(t, err, &oe)
+	assert.Equal(t, 8, oe.Offset)
+	assert.Equal(t, 8, oe.NumLines)
+	_, err = c.Line(-1)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidOffset)
+}
+
+func checkLine(t *testing.T, l gpiosim.Line, c *gpiosim.Chip, offset int) {
+	t.Helper()
+	assert.Same(t, c, l.Chip())
+	assert.Equal(t, offset, l.Offset())
+}
+
+func checkLinePull(t *testing.T, l gpiosim.Line, pull int) {
+	t.Helper()
+	v, err := l.Pull()
+	assert.Nil(t, err)
+	assert.Equal(t, pull, v)
+}
+
+func TestFakeChipBulk(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("bus", 8)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	c := &s.Chips[0]
+	err = c.SetPulls(map[int]int{1: 1, 3: 1, 4: 0})
+	assert.Nil(t, err)
+	levels, err := c.Levels()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 0, 1, 0, 0, 0, 0}, levels)
+
+	err = c.SetPullsMask(0xf0, 0x3a)
+	assert.Nil(t, err)
+	levels, err = c.Levels()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 0, 1, 1, 1, 0, 0}, levels)
+
+	assert.Nil(t, fb.Drive(c, 7, 1))
+	levels, err = c.Levels(7, 3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 1, 0}, levels)
+
+	// out of range - no changes applied
+	err = c.SetPulls(map[int]int{0: 1, 8: 1})
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidOffset)
+	err = c.SetPullsMask(0x101, 0x101)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidOffset)
+	checkChipPull(t, c, 0, 0)
+	levels, err = c.Levels(0, -1)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidOffset)
+	assert.Nil(t, levels)
+}
+
+func TestFakeWatchLevel(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8)),
+		gpiosim.WithLevelPollInterval(time.Millisecond),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	c := &s.Chips[0]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := c.WatchLevel(ctx, 2, 5)
+	require.Nil(t, err)
+
+	start := time.Now()
+	assert.Nil(t, fb.Drive(c, 5, 1))
+	checkLevelEvent(t, ch, 5, 1, start)
+	assert.Nil(t, c.Pullup(2))
+	checkLevelEvent(t, ch, 2, 1, start)
+	// unwatched line
+	assert.Nil(t, c.Pullup(3))
+	assert.Nil(t, fb.Drive(c, 5, 0))
+	checkLevelEvent(t, ch, 5, 0, start)
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		assert.Fail(t, "channel not closed")
+	}
+
+	// out of range
+	ch, err = c.WatchLevel(context.Background(), 1, 8)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidOffset)
+	assert.Nil(t, ch)
+
+	// closing the sim ends the watch
+	ch, err = c.WatchLevel(context.Background(), 2)
+	require.Nil(t, err)
+	assert.Nil(t, s.Close())
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		assert.Fail(t, "channel not closed")
+	}
+	_, err = c.Pull(2)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.ErrorIs(t, fb.Drive(c, 2, 1), fs.ErrNotExist)
+}
+
+func TestFakePlay(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	c := &s.Chips[0]
+	steps := gpiosim.Burst(3, 5*time.Millisecond, 10*time.Millisecond)
+	start := time.Now()
+	p, err := c.Play(context.Background(), 3, steps)
+	require.Nil(t, err)
+	transitions, err := p.Wait()
+	elapsed := time.Since(start)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, elapsed, 35*time.Millisecond)
+	require.Equal(t, len(steps), len(transitions))
+	first := transitions[0].Scheduled
+	var offset time.Duration
+	for i, tr := range transitions {
+		assert.Equal(t, steps[i].Level, tr.Level)
+		// scheduled relative to the start, so no drift
+		assert.Equal(t, offset, tr.Scheduled.Sub(first))
+		assert.False(t, tr.Timestamp.Before(tr.Scheduled))
+		offset += steps[i].Duration
+	}
+	select {
+	case <-p.Done():
+	default:
+		assert.Fail(t, "playback not done")
+	}
+	pull, err := c.Pull(3)
+	assert.Nil(t, err)
+	assert.Equal(t, gpiosim.LevelInactive, pull)
+
+	// cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err = c.Play(ctx, 4, gpiosim.Pulse(gpiosim.LevelActive, time.Minute))
+	require.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	transitions, err = p.Wait()
+	assert.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, len(transitions))
+	assert.Equal(t, gpiosim.LevelActive, transitions[0].Level)
+	pull, err = c.Pull(4)
+	assert.Nil(t, err)
+	assert.Equal(t, gpiosim.LevelActive, pull)
+
+	// invalid steps
+	p, err = c.Play(context.Background(), 4, []gpiosim.Step{{0, 0}, {2, 0}})
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidStep)
+	assert.Equal(t, gpiosim.StepError{Index: 1, Reason: "invalid level"}, err)
+	assert.Nil(t, p)
+	p, err = c.Play(context.Background(), 4, []gpiosim.Step{{1, -1}})
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidStep)
+	assert.Nil(t, p)
+
+	// out of range
+	p, err = c.Play(context.Background(), 8, steps)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidOffset)
+	assert.Nil(t, p)
+
+}
+
+func TestFakeRecordVCD(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8,
+			gpiosim.WithNamedLine(3, "LED 0"),
+		)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	c := &s.Chips[0]
+	var buf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := c.RecordVCD(ctx, &buf, []int{3, 4}, gpiosim.WithSamplePeriod(time.Millisecond))
+	require.Nil(t, err)
+
+	assert.Nil(t, fb.Drive(c, 3, 1))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, c.Pullup(4))
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.Nil(t, r.Wait())
+
+	vcd := buf.String()
+	header, body, ok := strings.Cut(vcd, "$enddefinitions $end\n")
+	require.True(t, ok)
+	assert.Contains(t, header, "$timescale 1 us $end\n")
+	assert.Contains(t, header, "$scope module left $end\n"+
+		"$var wire 1 ! LED_0 $end\n"+
+		"$var wire 1 \" LED_0_pull $end\n"+
+		"$var wire 1 # line4 $end\n"+
+		"$var wire 1 $ line4_pull $end\n"+
+		"$upscope $end\n")
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	require.Equal(t, 13, len(lines))
+	assert.Equal(t, []string{"#0", "$dumpvars", "0!", "0\"", "0#", "0$", "$end"}, lines[:7])
+	// the driven output
+	assert.Regexp(t, "^#[0-9]+$", lines[7])
+	assert.Equal(t, "1!", lines[8])
+	// the input follows the pull
+	assert.Regexp(t, "^#[0-9]+$", lines[9])
+	assert.Equal(t, []string{"1#", "1$"}, lines[10:12])
+	// the end of the recording
+	assert.Regexp(t, "^#[0-9]+$", lines[12])
+
+	// out of range
+	r, err = c.RecordVCD(ctx, &buf, []int{8})
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidOffset)
+	assert.Nil(t, r)
+}
+
+func TestFakeRecordVCDPlayTrace(t *testing.T) {
+	newSim := func(fb *gpiosim.FakeBackend) *gpiosim.Sim {
+		s, err := gpiosim.NewSim(
+			gpiosim.WithBackend(fb),
+			gpiosim.WithBank(gpiosim.NewBank("left", 8,
+				gpiosim.WithNamedLine(2, "BTN"),
+				gpiosim.WithNamedLine(5, "LED"),
+			)),
+		)
+		require.Nil(t, err)
+		return s
+	}
+	fb := gpiosim.NewFakeBackend()
+	rs := newSim(fb)
+	defer rs.Close()
+	rc := &rs.Chips[0]
+
+	var buf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := rc.RecordVCD(ctx, &buf, []int{2, 5})
+	require.Nil(t, err)
+	assert.Nil(t, fb.Drive(rc, 5, 1))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, rc.Pullup(2))
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.Nil(t, r.Wait())
+
+	trace, err := gpiosim.ParseVCD(&buf)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"BTN", "BTN_pull", "LED", "LED_pull"}, trace.Signals())
+
+	// the pulls are played, rather than the levels
+	ps := newSim(gpiosim.NewFakeBackend())
+	defer ps.Close()
+	p, err := ps.PlayTrace(context.Background(), trace)
+	require.Nil(t, err)
+	transitions, err := p.Wait()
+	assert.Nil(t, err)
+	require.Equal(t, 3, len(transitions))
+	for i, offset := range []int{2, 5, 2} {
+		assert.Equal(t, &ps.Chips[0], transitions[i].Line.Chip())
+		assert.Equal(t, offset, transitions[i].Line.Offset())
+	}
+	pull, err := ps.Chips[0].Pull(2)
+	assert.Nil(t, err)
+	assert.Equal(t, gpiosim.LevelActive, pull)
+	pull, err = ps.Chips[0].Pull(5)
+	assert.Nil(t, err)
+	assert.Equal(t, gpiosim.LevelInactive, pull)
+
+	// a mapped pull signal is played as is, alongside its level signal
+	led, err := ps.FindLine("LED")
+	require.Nil(t, err)
+	p, err = ps.PlayTrace(context.Background(), trace,
+		gpiosim.WithSignalLine("LED_pull", led))
+	require.Nil(t, err)
+	transitions, err = p.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(transitions))
+}
+
+func TestFakeRecordVCDPlayTraceMultiChip(t *testing.T) {
+	newSim := func(fb *gpiosim.FakeBackend) *gpiosim.Sim {
+		s, err := gpiosim.NewSim(
+			gpiosim.WithBackend(fb),
+			gpiosim.WithBank(gpiosim.NewBank("fish", 8,
+				gpiosim.WithNamedLine(5, "apple"),
+			)),
+			gpiosim.WithBank(gpiosim.NewBank("babel", 8,
+				gpiosim.WithNamedLine(7, "apple"),
+			)),
+		)
+		require.Nil(t, err)
+		return s
+	}
+	fb := gpiosim.NewFakeBackend()
+	rs := newSim(fb)
+	defer rs.Close()
+	lines := rs.FindLines("apple")
+	require.Equal(t, 2, len(lines))
+
+	var buf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := gpiosim.RecordVCD(ctx, &buf, lines)
+	require.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, rs.Chips[1].Pullup(7))
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.Nil(t, r.Wait())
+
+	trace, err := gpiosim.ParseVCD(&buf)
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"fish.apple", "fish.apple_pull", "babel.apple", "babel.apple_pull",
+	}, trace.Signals())
+
+	// each pull is played on the line of its chip
+	ps := newSim(gpiosim.NewFakeBackend())
+	defer ps.Close()
+	assert.Nil(t, ps.Chips[0].Pullup(5))
+	p, err := ps.PlayTrace(context.Background(), trace)
+	require.Nil(t, err)
+	transitions, err := p.Wait()
+	assert.Nil(t, err)
+	require.Equal(t, 3, len(transitions))
+	pull, err := ps.Chips[0].Pull(5)
+	assert.Nil(t, err)
+	assert.Equal(t, gpiosim.LevelInactive, pull)
+	pull, err = ps.Chips[1].Pull(7)
+	assert.Nil(t, err)
+	assert.Equal(t, gpiosim.LevelActive, pull)
+
+	// and on a single chip
+	p, err = ps.Chips[1].PlayTrace(context.Background(), trace,
+		gpiosim.WithUnmatchedSignalsIgnored())
+	require.Nil(t, err)
+	transitions, err = p.Wait()
+	assert.Nil(t, err)
+	require.Equal(t, 2, len(transitions))
+	for _, tr := range transitions {
+		assert.Equal(t, &ps.Chips[1], tr.Line.Chip())
+		assert.Equal(t, 7, tr.Line.Offset())
+	}
+}
+
+func TestFakePlayTrace(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8,
+			gpiosim.WithNamedLine(3, "BUTTON"),
+			gpiosim.WithNamedLine(4, "DUP"),
+		)),
+		gpiosim.WithBank(gpiosim.NewBank("right", 8,
+			gpiosim.WithNamedLine(4, "DUP"),
+		)),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	ms := time.Millisecond
+	trace := gpiosim.Trace{Events: []gpiosim.TraceEvent{
+		{Time: 100 * ms, Signal: "BUTTON", Level: 1},
+		{Time: 110 * ms, Signal: "OTHER", Level: 1},
+		{Time: 120 * ms, Signal: "BUTTON", Level: 0},
+		{Time: 130 * ms, Signal: "BUTTON", Level: 1},
+	}}
+	other, err := s.Chips[1].Line(6)
+	require.Nil(t, err)
+
+	// relative to the first event, and scaled
+	start := time.Now()
+	p, err := s.PlayTrace(context.Background(), trace,
+		gpiosim.WithSignalLine("OTHER", other),
+		gpiosim.WithTimeScale(2))
+	require.Nil(t, err)
+	transitions, err := p.Wait()
+	elapsed := time.Since(start)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, elapsed, 60*ms)
+	require.Equal(t, 4, len(transitions))
+	first := transitions[0].Scheduled
+	for i, offset := range []time.Duration{0, 20 * ms, 40 * ms, 60 * ms} {
+		assert.Equal(t, offset, transitions[i].Scheduled.Sub(first))
+		assert.Equal(t, trace.Events[i].Level, transitions[i].Level)
+	}
+	assert.Equal(t, 3, transitions[0].Line.Offset())
+	assert.Equal(t, other, transitions[1].Line)
+	pull, err := s.Chips[0].Pull(3)
+	assert.Nil(t, err)
+	assert.Equal(t, gpiosim.LevelActive, pull)
+	pull, err = other.Pull()
+	assert.Nil(t, err)
+	assert.Equal(t, gpiosim.LevelActive, pull)
+
+	// unmatched
+	p, err = s.PlayTrace(context.Background(), trace)
+	assert.ErrorIs(t, err, gpiosim.ErrLineNotFound)
+	assert.Nil(t, p)
+	p, err = s.Chips[0].PlayTrace(context.Background(), trace,
+		gpiosim.WithUnmatchedSignalsIgnored(),
+		gpiosim.WithTimeScale(0.1))
+	require.Nil(t, err)
+	transitions, err = p.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(transitions))
+
+	// ambiguous
+	trace.Events[1].Signal = "DUP"
+	p, err = s.PlayTrace(context.Background(), trace)
+	assert.ErrorIs(t, err, gpiosim.ErrAmbiguousLine)
+	assert.Nil(t, p)
+	p, err = s.Chips[1].PlayTrace(context.Background(), trace, gpiosim.WithSignalLine("BUTTON", other))
+	require.Nil(t, err)
+	_, err = p.Wait()
+	assert.Nil(t, err)
+
+	// invalid time scale
+	p, err = s.Chips[1].PlayTrace(context.Background(), trace, gpiosim.WithTimeScale(0))
+	assert.NotNil(t, err)
+	assert.Nil(t, p)
+
+	// cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err = s.Chips[0].PlayTrace(ctx, trace,
+		gpiosim.WithUnmatchedSignalsIgnored(),
+		gpiosim.WithTimeScale(1000))
+	require.Nil(t, err)
+	cancel()
+	transitions, err = p.Wait()
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.LessOrEqual(t, len(transitions), 1)
+}
+
+func TestFakeWire(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8)),
+		gpiosim.WithBank(gpiosim.NewBank("right", 8)),
+		gpiosim.WithLevelPollInterval(time.Millisecond),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	left := &s.Chips[0]
+	right := &s.Chips[1]
+	from, err := left.Line(2)
+	require.Nil(t, err)
+	to, err := right.Line(5)
+	require.Nil(t, err)
+	inv, err := left.Line(6)
+	require.Nil(t, err)
+
+	require.Nil(t, fb.Drive(left, 2, 1))
+	w, err := s.Wire(from, to)
+	require.Nil(t, err)
+	assert.Equal(t, from, w.From())
+	assert.Equal(t, to, w.To())
+	// initial level
+	waitPull(t, to, gpiosim.LevelActive)
+
+	// across chips
+	require.Nil(t, fb.Drive(left, 2, 0))
+	waitPull(t, to, gpiosim.LevelInactive)
+
+	// inverted and delayed, and chained from the input
+	wi, err := s.Wire(to, inv, gpiosim.WithInverted(), gpiosim.WithPropagationDelay(20*time.Millisecond))
+	require.Nil(t, err)
+	waitPull(t, inv, gpiosim.LevelActive)
+	start := time.Now()
+	require.Nil(t, fb.Drive(left, 2, 1))
+	waitPull(t, to, gpiosim.LevelActive)
+	waitPull(t, inv, gpiosim.LevelInactive)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// disconnected
+	assert.Nil(t, w.Close())
+	assert.Nil(t, w.Close())
+	select {
+	case <-w.Done():
+	default:
+		assert.Fail(t, "wire not done")
+	}
+	require.Nil(t, fb.Drive(left, 2, 0))
+	time.Sleep(20 * time.Millisecond)
+	checkLinePull(t, to, gpiosim.LevelActive)
+
+	// invalid
+	_, err = s.Wire(from, from)
+	assert.NotNil(t, err)
+	_, err = s.Wire(from, to, gpiosim.WithPropagationDelay(-1))
+	assert.NotNil(t, err)
+	other, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("other", 8)),
+	)
+	require.Nil(t, err)
+	defer other.Close()
+	ol, err := other.Chips[0].Line(0)
+	require.Nil(t, err)
+	_, err = s.Wire(from, ol)
+	assert.NotNil(t, err)
+
+	// closed with the sim
+	assert.Nil(t, s.Close())
+	select {
+	case <-wi.Done():
+	default:
+		assert.Fail(t, "wire not closed with sim")
+	}
+}
+
+func TestFakeBus(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8)),
+		gpiosim.WithBank(gpiosim.NewBank("right", 8)),
+		gpiosim.WithLevelPollInterval(time.Millisecond),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	left := &s.Chips[0]
+	right := &s.Chips[1]
+	l1, err := left.Line(1)
+	require.Nil(t, err)
+	l2, err := left.Line(2)
+	require.Nil(t, err)
+	r3, err := right.Line(3)
+	require.Nil(t, err)
+
+	b, err := s.Bus(l1, l2, r3)
+	require.Nil(t, err)
+	assert.Equal(t, []gpiosim.Line{l1, l2, r3}, b.Lines())
+	// pulled up
+	assert.Equal(t, gpiosim.LevelActive, b.Level())
+	checkLinePull(t, l1, gpiosim.LevelActive)
+	checkLinePull(t, l2, gpiosim.LevelActive)
+	checkLinePull(t, r3, gpiosim.LevelActive)
+
+	// driven low by a line
+	require.Nil(t, fb.Drive(left, 1, 0))
+	waitPull(t, l2, gpiosim.LevelInactive)
+	waitPull(t, r3, gpiosim.LevelInactive)
+	assert.Equal(t, gpiosim.LevelInactive, b.Level())
+	checkLinePull(t, l1, gpiosim.LevelActive)
+
+	// driven low by a second line, across chips, then the first released
+	require.Nil(t, fb.Drive(right, 3, 0))
+	require.Nil(t, fb.Release(left, 1))
+	waitPull(t, l1, gpiosim.LevelInactive)
+	waitPull(t, r3, gpiosim.LevelActive)
+	assert.Equal(t, gpiosim.LevelInactive, b.Level())
+	checkLinePull(t, l2, gpiosim.LevelInactive)
+	level, err := l1.Level()
+	assert.Nil(t, err)
+	assert.Equal(t, gpiosim.LevelInactive, level)
+
+	// all released
+	require.Nil(t, fb.Release(right, 3))
+	waitPull(t, l1, gpiosim.LevelActive)
+	waitPull(t, l2, gpiosim.LevelActive)
+	assert.Equal(t, gpiosim.LevelActive, b.Level())
+
+	// driven high is not driving the bus
+	require.Nil(t, fb.Drive(left, 2, 1))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, gpiosim.LevelActive, b.Level())
+
+	// external drivers
+	assert.Nil(t, b.DriveLow("dev1"))
+	assert.Nil(t, b.DriveLow("dev2"))
+	assert.Equal(t, gpiosim.LevelInactive, b.Level())
+	checkLinePull(t, l1, gpiosim.LevelInactive)
+	checkLinePull(t, r3, gpiosim.LevelInactive)
+	assert.Nil(t, b.Release("dev1"))
+	assert.Equal(t, gpiosim.LevelInactive, b.Level())
+	assert.Nil(t, b.Release("dev2"))
+	assert.Equal(t, gpiosim.LevelActive, b.Level())
+	checkLinePull(t, l1, gpiosim.LevelActive)
+	checkLinePull(t, r3, gpiosim.LevelActive)
+
+	// disconnected
+	assert.Nil(t, b.Close())
+	assert.Nil(t, b.Close())
+	assert.NotNil(t, b.DriveLow("dev1"))
+	require.Nil(t, fb.Drive(left, 1, 0))
+	time.Sleep(10 * time.Millisecond)
+	checkLinePull(t, r3, gpiosim.LevelActive)
+
+	// invalid
+	_, err = s.Bus()
+	assert.NotNil(t, err)
+	_, err = s.Bus(l1, l2, l1)
+	assert.NotNil(t, err)
+
+	// closed with the sim
+	b, err = s.Bus(l2, r3)
+	require.Nil(t, err)
+	assert.Nil(t, s.Close())
+	select {
+	case <-b.Done():
+	default:
+		assert.Fail(t, "bus not closed with sim")
+	}
+}
+
+// ackDevice acknowledges each request by pulling ack to the level of req.
+type ackDevice struct {
+	ack      gpiosim.Line
+	changes  int
+	detached bool
+	failAt   int
+}
+
+func (d *ackDevice) Lines() []string {
+	return []string{"REQ", "ACK"}
+}
+
+func (d *ackDevice) Attach(lines []gpiosim.Line) error {
+	d.ack = lines[1]
+	return d.ack.Pulldown()
+}
+
+func (d *ackDevice) LevelChanged(l gpiosim.Line, evt gpiosim.LevelEvent) error {
+	d.changes++
+	if d.changes == d.failAt {
+		return errors.New("device failed")
+	}
+	if l.Name() != "REQ" {
+		return nil
+	}
+	return d.ack.SetPull(evt.Level)
+}
+
+func (d *ackDevice) Detach() error {
+	d.detached = true
+	return nil
+}
+
+func TestFakeAttachDevice(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("left", 8,
+			gpiosim.WithNamedLine(1, "REQ"),
+		)),
+		gpiosim.WithBank(gpiosim.NewBank("right", 8,
+			gpiosim.WithNamedLine(2, "ACK"),
+			gpiosim.WithNamedLine(3, "DUP"),
+			gpiosim.WithNamedLine(4, "DUP"),
+		)),
+		gpiosim.WithLevelPollInterval(time.Millisecond),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	left := &s.Chips[0]
+	ack, err := s.FindLine("ACK")
+	require.Nil(t, err)
+	require.Nil(t, ack.Pullup())
+
+	d := ackDevice{}
+	ad, err := s.AttachDevice(&d)
+	require.Nil(t, err)
+	assert.Equal(t, &d, ad.Device())
+	checkLinePull(t, ack, gpiosim.LevelInactive)
+
+	require.Nil(t, fb.Drive(left, 1, 1))
+	waitPull(t, ack, gpiosim.LevelActive)
+	require.Nil(t, fb.Drive(left, 1, 0))
+	waitPull(t, ack, gpiosim.LevelInactive)
+	assert.Nil(t, ad.Err())
+	assert.Nil(t, ad.Close())
+	assert.True(t, d.detached)
+	select {
+	case <-ad.Done():
+	default:
+		assert.Fail(t, "device not detached")
+	}
+
+	// device error
+	d = ackDevice{failAt: 2}
+	ad, err = s.AttachDevice(&d)
+	require.Nil(t, err)
+	require.Nil(t, fb.Drive(left, 1, 1))
+	waitPull(t, ack, gpiosim.LevelActive)
+	require.Nil(t, fb.Drive(left, 1, 0))
+	select {
+	case <-ad.Done():
+	case <-time.After(time.Second):
+		assert.Fail(t, "device not detached")
+	}
+	assert.EqualError(t, ad.Err(), "device failed")
+	assert.EqualError(t, ad.Close(), "device failed")
+	assert.True(t, d.detached)
+
+	// lines not found
+	_, err = s.AttachDevice(&lineDevice{"MISSING"})
+	assert.ErrorIs(t, err, gpiosim.ErrLineNotFound)
+	_, err = s.AttachDevice(&lineDevice{"DUP"})
+	assert.ErrorIs(t, err, gpiosim.ErrAmbiguousLine)
+
+	// detached with the sim
+	d = ackDevice{}
+	ad, err = s.AttachDevice(&d)
+	require.Nil(t, err)
+	assert.Nil(t, s.Close())
+	select {
+	case <-ad.Done():
+	default:
+		assert.Fail(t, "device not detached with sim")
+	}
+	assert.True(t, d.detached)
+}
+
+// lineDevice attaches to a single line, and does nothing.
+type lineDevice struct {
+	name string
+}
+
+func (d *lineDevice) Lines() []string                                     { return []string{d.name} }
+func (d *lineDevice) Attach([]gpiosim.Line) error                         { return nil }
+func (d *lineDevice) LevelChanged(gpiosim.Line, gpiosim.LevelEvent) error { return nil }
+func (d *lineDevice) Detach() error                                       { return nil }
+
+func waitPull(t *testing.T, l gpiosim.Line, pull int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		v, err := l.Pull()
+		require.Nil(t, err)
+		if v == pull {
+			return
+		}
+		if time.Now().After(deadline) {
+			assert.Fail(t, "pull not set", "offset %d", l.Offset())
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func checkLevelEvent(t *testing.T, ch <-chan gpiosim.LevelEvent, offset, level int, after time.Time) {
+	t.Helper()
+	select {
+	case evt, ok := <-ch:
+		require.True(t, ok)
+		assert.Equal(t, offset, evt.Offset)
+		assert.Equal(t, level, evt.Level)
+		assert.False(t, evt.Timestamp.Before(after))
+	case <-time.After(time.Second):
+		assert.Fail(t, "no event", "offset %d", offset)
+	}
+}
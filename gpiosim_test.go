@@ -6,6 +6,7 @@ package gpiosim_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -324,3 +325,81 @@ func TestSimpletonLevel(t *testing.T) {
 	checkSimpletonLevel(t, s, offset, 0)
 	checkSimpletonPull(t, s, offset, 0)
 }
+
+func waitLineLevel(t *testing.T, l *gpiocdev.Line, xv int) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		v, err := l.Value()
+		return err == nil && v == xv
+	}, time.Second, time.Millisecond)
+}
+
+func TestBus(t *testing.T) {
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBank(gpiosim.NewBank("left", 8)),
+		gpiosim.WithLevelPollInterval(time.Millisecond),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	c := &s.Chips[0]
+	var lines []gpiosim.Line
+	for _, offset := range []int{1, 2, 3} {
+		l, err := c.Line(offset)
+		require.Nil(t, err)
+		lines = append(lines, l)
+	}
+	b, err := s.Bus(lines...)
+	require.Nil(t, err)
+	defer b.Close()
+
+	reader, err := gpiocdev.RequestLine(c.DevPath(), 1, gpiocdev.AsInput)
+	require.Nil(t, err)
+	defer reader.Close()
+	checkLineLevel(t, reader, 1)
+
+	// driven low, then released by reconfiguring as an input
+	driver, err := gpiocdev.RequestLine(c.DevPath(), 2, gpiocdev.AsOutput(0))
+	require.Nil(t, err)
+	defer driver.Close()
+	waitLineLevel(t, reader, 0)
+	assert.Equal(t, gpiosim.LevelInactive, b.Level())
+	require.Nil(t, driver.Reconfigure(gpiocdev.AsInput))
+	waitLineLevel(t, reader, 1)
+	waitLineLevel(t, driver, 1)
+	assert.Equal(t, gpiosim.LevelActive, b.Level())
+
+	// driven low by an open-drain line
+	od, err := gpiocdev.RequestLine(c.DevPath(), 3, gpiocdev.AsOpenDrain, gpiocdev.AsOutput(0))
+	require.Nil(t, err)
+	waitLineLevel(t, reader, 0)
+	waitLineLevel(t, driver, 0)
+	assert.Equal(t, gpiosim.LevelInactive, b.Level())
+
+	// and released by reconfiguring as an input
+	require.Nil(t, od.Reconfigure(gpiocdev.AsInput))
+	waitLineLevel(t, reader, 1)
+	waitLineLevel(t, driver, 1)
+	waitLineLevel(t, od, 1)
+	assert.Equal(t, gpiosim.LevelActive, b.Level())
+	od.Close()
+}
+
+func BenchmarkChipToggle(b *testing.B) {
+	benchmarkChipToggle(b)
+}
+
+func BenchmarkChipToggleCached(b *testing.B) {
+	benchmarkChipToggle(b, gpiosim.WithCachedLineFiles())
+}
+
+func benchmarkChipToggle(b *testing.B, options ...gpiosim.NewSimOption) {
+	s, err := gpiosim.NewSimpleton(8, options...)
+	require.Nil(b, err)
+	defer s.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Toggle(3)
+	}
+}
@@ -0,0 +1,364 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"sync"
+	"time"
+)
+
+// I2CTransaction describes a transfer between the I2C master and a target,
+// from a start condition to the following stop or repeated start.
+type I2CTransaction struct {
+	// The 7-bit address of the target.
+	Addr uint8
+
+	// True if the master read from the target.
+	Read bool
+
+	// True if the address was acknowledged by a target.
+	Acked bool
+
+	// The bytes transferred following the address.
+	//
+	// For writes this includes the register address.
+	Data []byte
+}
+
+// The states of the I2CTarget protocol decoder.
+const (
+	// Waiting for a start condition.
+	i2cIdle = iota
+
+	// Receiving the address.
+	i2cAddress
+
+	// Receiving data from the master.
+	i2cWrite
+
+	// Sending data to the master.
+	i2cRead
+
+	// Ignoring the bus until the next start condition.
+	i2cIgnore
+)
+
+// I2CTarget is a Device that emulates one or more I2C targets, each with a
+// register map, on a bit-banged bus.
+//
+// The target decodes the start and stop conditions, addresses and data from
+// the levels of the SDA and SCL lines driven by the master, and drives SDA by
+// setting its pull.
+// The master should release SDA, so it can read the levels the target pulls
+// it to, by freeing the line or requesting it as an input, and drive SDA low
+// by requesting it as an output.
+// Requesting SDA as open-drain does not work with the gpio-sim backend, as a
+// released open-drain line remains an output, and gpio-sim does not apply the
+// pull to outputs.
+//
+// Writes set the register address, from the first byte written, and then
+// write the following bytes to consecutive registers.
+// Reads return the contents of consecutive registers, starting from the
+// register address.
+// The register address wraps at the end of the register map.
+//
+// Only 7-bit addressing is supported, and clock stretching is not.
+// Changes to the levels are detected as per Chip.WatchLevel, so the master
+// must hold each level for longer than the level poll interval, unless the
+// backend notifies of changes.
+type I2CTarget struct {
+	// The names of the lines.
+	sda string
+	scl string
+
+	// The lines, set by Attach.
+	sdaLine Line
+	sclLine Line
+
+	mu sync.Mutex
+
+	// The register maps of the targets, keyed by address.
+	regs map[uint8][]byte
+
+	// The register address of each target.
+	ptrs map[uint8]int
+
+	// The completed transactions.
+	log []I2CTransaction
+
+	// The transaction in progress.
+	txn *I2CTransaction
+
+	// The state of the protocol decoder.
+	state int
+
+	// True if SCL was high, as of the last change reported for SCL.
+	sclHigh bool
+
+	// The time SCL was detected going high.
+	sclRise time.Time
+
+	// The number of bits of the current byte transferred.
+	n int
+
+	// The byte being received or sent.
+	shift byte
+
+	// True during the acknowledge clock, following the 8th bit of a byte.
+	ack bool
+
+	// True if the master acknowledged the last byte read.
+	masterAck bool
+
+	// True until the register address has been written.
+	first bool
+}
+
+// NewI2CTarget creates an I2CTarget on the lines with the given names.
+//
+// The target does not acknowledge any addresses until its register maps are
+// added with SetRegisters.
+func NewI2CTarget(sda, scl string) *I2CTarget {
+	return &I2CTarget{
+		sda:  sda,
+		scl:  scl,
+		regs: make(map[uint8][]byte),
+		ptrs: make(map[uint8]int),
+	}
+}
+
+// SetRegisters sets the register map of the target at the address, so the
+// address is acknowledged.
+//
+// The registers are copied, and the register address of the target is reset
+// to 0.
+func (t *I2CTarget) SetRegisters(addr uint8, regs []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.regs[addr] = append([]byte{}, regs...)
+	t.ptrs[addr] = 0
+}
+
+// Registers returns a copy of the register map of the target at the address,
+// or nil if there is no target at the address.
+func (t *I2CTarget) Registers(addr uint8) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	regs, ok := t.regs[addr]
+	if !ok {
+		return nil
+	}
+	return append([]byte{}, regs...)
+}
+
+// Transactions returns the completed transactions, in the order they
+// occurred.
+func (t *I2CTarget) Transactions() []I2CTransaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]I2CTransaction(nil), t.log...)
+}
+
+// Lines returns the names of the SCL and SDA lines.
+//
+// SCL is first so, if the lines are on the same chip, a change to SCL is
+// reported before a change to SDA detected at the same time.
+func (t *I2CTarget) Lines() []string {
+	return []string{t.scl, t.sda}
+}
+
+// Attach pulls up the SCL and SDA lines, as the bus is idle.
+func (t *I2CTarget) Attach(lines []Line) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sclLine = lines[0]
+	t.sdaLine = lines[1]
+	t.state = i2cIdle
+	t.txn = nil
+	for _, l := range lines {
+		if err := l.Pullup(); err != nil {
+			return err
+		}
+	}
+	scl, err := t.sclLine.Level()
+	t.sclHigh = scl == LevelActive
+	t.sclRise = time.Time{}
+	return err
+}
+
+// LevelChanged decodes the change to the level of SDA or SCL.
+func (t *I2CTarget) LevelChanged(l Line, evt LevelEvent) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if l.Chip() == t.sdaLine.Chip() && l.Offset() == t.sdaLine.Offset() {
+		// SCL must have gone high before this change was detected, as SCL
+		// going high at the same time is the master clocking in a bit it has
+		// just set up.
+		if !t.sclHigh || !t.sclRise.Before(evt.Timestamp) {
+			return nil
+		}
+		if evt.Level == LevelInactive {
+			return t.start()
+		}
+		return t.stop()
+	}
+	t.sclHigh = evt.Level == LevelActive
+	if t.sclHigh {
+		t.sclRise = evt.Timestamp
+		return t.clockRising()
+	}
+	return t.clockFalling()
+}
+
+// Detach completes any transaction in progress.
+func (t *I2CTarget) Detach() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.endTransaction()
+	return nil
+}
+
+// start handles a start, or repeated start, condition.
+func (t *I2CTarget) start() error {
+	t.endTransaction()
+	t.state = i2cAddress
+	t.n = 0
+	t.shift = 0
+	t.ack = false
+	return t.sdaLine.Pullup()
+}
+
+// stop handles a stop condition.
+func (t *I2CTarget) stop() error {
+	t.endTransaction()
+	t.state = i2cIdle
+	return t.sdaLine.Pullup()
+}
+
+func (t *I2CTarget) endTransaction() {
+	if t.txn != nil {
+		t.log = append(t.log, *t.txn)
+		t.txn = nil
+	}
+}
+
+// clockRising samples SDA, when driven by the master.
+func (t *I2CTarget) clockRising() error {
+	if t.state == i2cIdle || t.state == i2cIgnore {
+		return nil
+	}
+	if t.ack {
+		if t.state == i2cRead {
+			v, err := t.sdaLine.Level()
+			if err != nil {
+				return err
+			}
+			t.masterAck = v == LevelInactive
+		}
+		return nil
+	}
+	if t.state == i2cRead || t.n == 8 {
+		return nil
+	}
+	v, err := t.sdaLine.Level()
+	if err != nil {
+		return err
+	}
+	t.shift = t.shift<<1 | byte(v)
+	t.n++
+	return nil
+}
+
+// clockFalling handles the end of a byte, or its acknowledge, and drives SDA
+// when the target is sending.
+func (t *I2CTarget) clockFalling() error {
+	switch {
+	case t.state == i2cIdle || t.state == i2cIgnore:
+		return nil
+	case t.ack:
+		// end of the acknowledge clock
+		t.ack = false
+		t.n = 0
+		t.shift = 0
+		switch t.state {
+		case i2cAddress:
+			if t.txn.Read {
+				t.state = i2cRead
+				return t.sendByte()
+			}
+			t.state = i2cWrite
+			t.first = true
+		case i2cRead:
+			if t.masterAck {
+				return t.sendByte()
+			}
+			t.state = i2cIgnore
+		}
+		return t.sdaLine.Pullup()
+	case t.n == 8:
+		// end of the byte, so the acknowledge clock follows
+		t.ack = true
+		switch t.state {
+		case i2cAddress:
+			addr := t.shift >> 1
+			read := t.shift&1 == 1
+			if _, ok := t.regs[addr]; !ok {
+				t.log = append(t.log, I2CTransaction{Addr: addr, Read: read})
+				t.state = i2cIgnore
+				t.ack = false
+				return nil
+			}
+			t.txn = &I2CTransaction{Addr: addr, Read: read, Acked: true}
+		case i2cWrite:
+			t.write(t.shift)
+		case i2cRead:
+			// the master acknowledges
+			return t.sdaLine.Pullup()
+		}
+		return t.sdaLine.Pulldown()
+	case t.state == i2cRead:
+		return t.sendBit()
+	}
+	return nil
+}
+
+// write writes the byte received from the master to the register map.
+func (t *I2CTarget) write(b byte) {
+	t.txn.Data = append(t.txn.Data, b)
+	regs := t.regs[t.txn.Addr]
+	if t.first {
+		t.first = false
+		t.ptrs[t.txn.Addr] = int(b)
+		return
+	}
+	if len(regs) == 0 {
+		return
+	}
+	p := t.ptrs[t.txn.Addr] % len(regs)
+	regs[p] = b
+	t.ptrs[t.txn.Addr] = (p + 1) % len(regs)
+}
+
+// sendByte loads the next register to be read and drives its first bit.
+func (t *I2CTarget) sendByte() error {
+	regs := t.regs[t.txn.Addr]
+	t.shift = 0xff
+	if len(regs) != 0 {
+		p := t.ptrs[t.txn.Addr] % len(regs)
+		t.shift = regs[p]
+		t.ptrs[t.txn.Addr] = (p + 1) % len(regs)
+	}
+	t.txn.Data = append(t.txn.Data, t.shift)
+	t.n = 0
+	return t.sendBit()
+}
+
+// sendBit drives the next bit of the byte being read, most significant
+// first.
+func (t *I2CTarget) sendBit() error {
+	bit := int(t.shift>>(7-t.n)) & 1
+	t.n++
+	return t.sdaLine.SetPull(bit)
+}
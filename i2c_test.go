@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warthog618/go-gpiocdev"
+	"github.com/warthog618/go-gpiosim"
+)
+
+// i2cLines drives and reads the lines of the bus on behalf of the master.
+type i2cLines interface {
+	// set drives the line low, or releases it so it is pulled high.
+	set(offset, level int) error
+
+	get(offset int) (int, error)
+}
+
+// fakeI2CLines emulates open-drain lines using the fake backend.
+type fakeI2CLines struct {
+	fb *gpiosim.FakeBackend
+	c  *gpiosim.Chip
+}
+
+func (l fakeI2CLines) set(offset, level int) error {
+	if level == gpiosim.LevelActive {
+		return l.fb.Release(l.c, offset)
+	}
+	return l.fb.Drive(l.c, offset, level)
+}
+
+func (l fakeI2CLines) get(offset int) (int, error) {
+	return l.c.Level(offset)
+}
+
+// cdevI2CLines drives lines of a gpiochip, releasing a line by requesting it
+// as an input and driving it low by requesting it as an output.
+type cdevI2CLines struct {
+	c     *gpiosim.Chip
+	lines map[int]*gpiocdev.Line
+}
+
+func (l cdevI2CLines) set(offset, level int) error {
+	if old, ok := l.lines[offset]; ok {
+		old.Close()
+		delete(l.lines, offset)
+	}
+	option := gpiocdev.LineReqOption(gpiocdev.AsInput)
+	if level == gpiosim.LevelInactive {
+		option = gpiocdev.AsOutput(0)
+	}
+	line, err := gpiocdev.RequestLine(l.c.DevPath(), offset, option)
+	if err != nil {
+		return err
+	}
+	l.lines[offset] = line
+	return nil
+}
+
+func (l cdevI2CLines) get(offset int) (int, error) {
+	line, ok := l.lines[offset]
+	if !ok {
+		return l.c.Level(offset)
+	}
+	return line.Value()
+}
+
+func (l cdevI2CLines) close() {
+	for _, line := range l.lines {
+		line.Close()
+	}
+}
+
+// seenDevice wraps a device, recording the last level reported to it for
+// each line.
+type seenDevice struct {
+	gpiosim.Device
+
+	mu     sync.Mutex
+	levels map[int]int
+}
+
+func (d *seenDevice) Attach(lines []gpiosim.Line) error {
+	if err := d.Device.Attach(lines); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, l := range lines {
+		v, err := l.Level()
+		if err != nil {
+			return err
+		}
+		d.levels[l.Offset()] = v
+	}
+	return nil
+}
+
+func (d *seenDevice) LevelChanged(l gpiosim.Line, evt gpiosim.LevelEvent) error {
+	err := d.Device.LevelChanged(l, evt)
+	d.mu.Lock()
+	d.levels[evt.Offset] = evt.Level
+	d.mu.Unlock()
+	return err
+}
+
+func (d *seenDevice) seen(offset, level int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.levels[offset] == level
+}
+
+// i2cMaster bit-bangs I2C on open-drain lines.
+type i2cMaster struct {
+	t     *testing.T
+	lines i2cLines
+	c     *gpiosim.Chip
+	sda   int
+	scl   int
+	half  time.Duration
+
+	// The device wrapping the target, so the master can wait for the target
+	// to see each change, as the changes may be detected late.
+	dev *seenDevice
+}
+
+// set drives the line low, or releases it so it is pulled high, and waits for
+// the target to see the resulting level.
+func (m *i2cMaster) set(offset, level int) {
+	require.Nil(m.t, m.lines.set(offset, level))
+	require.Eventually(m.t, func() bool {
+		v, err := m.c.Level(offset)
+		return err == nil && m.dev.seen(offset, v)
+	}, time.Second, time.Millisecond)
+}
+
+// start issues a start, or repeated start, condition.
+func (m *i2cMaster) start() {
+	m.set(m.sda, 1)
+	time.Sleep(m.half)
+	m.set(m.scl, 1)
+	time.Sleep(m.half)
+	m.set(m.sda, 0)
+	time.Sleep(m.half)
+	m.set(m.scl, 0)
+}
+
+func (m *i2cMaster) stop() {
+	m.set(m.sda, 0)
+	time.Sleep(m.half)
+	m.set(m.scl, 1)
+	time.Sleep(m.half)
+	m.set(m.sda, 1)
+	time.Sleep(m.half)
+}
+
+func (m *i2cMaster) writeBit(bit int) {
+	m.set(m.sda, bit)
+	time.Sleep(m.half)
+	m.set(m.scl, 1)
+	time.Sleep(m.half)
+	m.set(m.scl, 0)
+}
+
+func (m *i2cMaster) readBit() int {
+	m.set(m.sda, 1)
+	time.Sleep(m.half)
+	m.set(m.scl, 1)
+	time.Sleep(m.half)
+	v, err := m.lines.get(m.sda)
+	require.Nil(m.t, err)
+	m.set(m.scl, 0)
+	return v
+}
+
+// writeByte writes the byte and returns true if it was acknowledged.
+func (m *i2cMaster) writeByte(b byte) bool {
+	for i := 7; i >= 0; i-- {
+		m.writeBit(int(b>>i) & 1)
+	}
+	return m.readBit() == 0
+}
+
+func (m *i2cMaster) readByte(ack bool) byte {
+	var b byte
+	for i := 0; i < 8; i++ {
+		b = b<<1 | byte(m.readBit())
+	}
+	if ack {
+		m.writeBit(0)
+	} else {
+		m.writeBit(1)
+	}
+	return b
+}
+
+func TestFakeI2CTarget(t *testing.T) {
+	fb := gpiosim.NewFakeBackend()
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBackend(fb),
+		gpiosim.WithBank(gpiosim.NewBank("i2c", 4,
+			gpiosim.WithNamedLine(1, "SCL"),
+			gpiosim.WithNamedLine(2, "SDA"),
+		)),
+		gpiosim.WithLevelPollInterval(time.Millisecond),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	testI2CTarget(t, s, fakeI2CLines{fb, &s.Chips[0]})
+}
+
+func TestI2CTarget(t *testing.T) {
+	s, err := gpiosim.NewSim(
+		gpiosim.WithBank(gpiosim.NewBank("i2c", 4,
+			gpiosim.WithNamedLine(1, "SCL"),
+			gpiosim.WithNamedLine(2, "SDA"),
+		)),
+		gpiosim.WithLevelPollInterval(time.Millisecond),
+	)
+	require.Nil(t, err)
+	defer s.Close()
+
+	lines := cdevI2CLines{&s.Chips[0], make(map[int]*gpiocdev.Line)}
+	defer lines.close()
+	testI2CTarget(t, s, lines)
+}
+
+func testI2CTarget(t *testing.T, s *gpiosim.Sim, lines i2cLines) {
+	target := gpiosim.NewI2CTarget("SDA", "SCL")
+	target.SetRegisters(0x50, []byte{0, 1, 2, 3})
+	assert.Nil(t, target.Registers(0x51))
+	dev := &seenDevice{Device: target, levels: make(map[int]int)}
+	ad, err := s.AttachDevice(dev)
+	require.Nil(t, err)
+
+	m := i2cMaster{
+		t:     t,
+		lines: lines,
+		c:     &s.Chips[0],
+		sda:   2,
+		scl:   1,
+		half:  2 * time.Millisecond,
+		dev:   dev,
+	}
+
+	// write registers, wrapping at the end of the map
+	m.start()
+	assert.True(t, m.writeByte(0x50<<1))
+	assert.True(t, m.writeByte(0x02))
+	assert.True(t, m.writeByte(0xab))
+	assert.True(t, m.writeByte(0xcd))
+	assert.True(t, m.writeByte(0xef))
+	m.stop()
+	assert.Equal(t, []byte{0xef, 1, 0xab, 0xcd}, target.Registers(0x50))
+
+	// set the register address, then read with a repeated start
+	m.start()
+	assert.True(t, m.writeByte(0x50<<1))
+	assert.True(t, m.writeByte(0x02))
+	m.start()
+	assert.True(t, m.writeByte(0x50<<1|1))
+	assert.Equal(t, byte(0xab), m.readByte(true))
+	assert.Equal(t, byte(0xcd), m.readByte(false))
+	m.stop()
+
+	// unknown address
+	m.start()
+	assert.False(t, m.writeByte(0x42<<1))
+	m.stop()
+
+	assert.Nil(t, ad.Close())
+	assert.Equal(t, []gpiosim.I2CTransaction{
+		{Addr: 0x50, Acked: true, Data: []byte{0x02, 0xab, 0xcd, 0xef}},
+		{Addr: 0x50, Acked: true, Data: []byte{0x02}},
+		{Addr: 0x50, Read: true, Acked: true, Data: []byte{0xab, 0xcd}},
+		{Addr: 0x42},
+	}, target.Transactions())
+}
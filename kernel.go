@@ -0,0 +1,503 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/warthog618/go-gpiocdev/uapi"
+)
+
+// kernelBackend provides sims using the gpio-sim kernel module.
+type kernelBackend struct {
+	// The mountpoint of configfs.
+	//
+	// If empty then the mountpoint is determined from the system, loading
+	// gpio-sim and mounting configfs if necessary.
+	configfsRoot string
+
+	// The root of sysfs.
+	//
+	// If empty then "/sys" is used.
+	sysfsRoot string
+
+	// The directory containing the gpiochip device nodes.
+	//
+	// If empty then "/dev" is used.
+	devRoot string
+
+	// The period to wait for the gpiochip device nodes to be created.
+	//
+	// If zero then the defaultDevNodeTimeout is used.
+	devNodeTimeout time.Duration
+
+	// True if the sim was opened by OpenSim, rather than created by NewSim.
+	opened bool
+
+	// True if closing the sim only detaches from it, leaving it in place.
+	detach bool
+
+	// True if the sysfs files of the lines are kept open between accesses.
+	cacheLineFiles bool
+
+	// The file system operations used to access configfs, sysfs and dev.
+	//
+	// If nil then the os file system is used.
+	fs sysFS
+}
+
+// live creates the gpio-sim configuration for the sim and takes it live.
+//
+// If any step fails then everything created so far is removed.
+func (k *kernelBackend) live(s *Sim) error {
+	if k.fs == nil {
+		k.fs = osFS{}
+	}
+	configfsPath, err := k.findConfigfsPath()
+	if err != nil {
+		return err
+	}
+	configfsPath = path.Join(configfsPath, s.Name)
+	if _, err := k.fs.stat(configfsPath); err == nil {
+		return SimExistsError{s.Name}
+	}
+	s.configfsPath = configfsPath
+	if err = k.goLive(s); err != nil {
+		if rerr := k.cleanupConfigfs(s); rerr != nil {
+			return errors.WithMessagef(err, "rollback failed: %s", rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// goLive performs the steps to take the sim live, once the location of the
+// sim in configfs has been determined.
+func (k *kernelBackend) goLive(s *Sim) error {
+	err := k.setupConfigfs(s)
+	if err == nil {
+		err = k.writeAttr(s.configfsPath, "live", "1")
+	}
+	if err != nil {
+		return err
+	}
+	devName, err := k.readAttr(s.configfsPath, "dev_name")
+	if err != nil {
+		return err
+	}
+	for i := range s.Chips {
+		bankPath := path.Join(s.configfsPath, fmt.Sprintf("bank%d", i))
+		chipName, err := k.readAttr(bankPath, "chip_name")
+		if err != nil {
+			return err
+		}
+		c := &s.Chips[i]
+		c.configfsPath = bankPath
+		c.devName = devName
+		c.chipName = chipName
+		c.devPath = path.Join(k.devPath(), chipName)
+		c.sysfsPath = path.Join(k.sysfsPath(), "devices/platform", devName, chipName)
+		if err := k.waitDevNode(c); err != nil {
+			return err
+		}
+		c.lines = k.newLines(c.sysfsPath)
+	}
+	return nil
+}
+
+// close removes the gpio-sim configuration for the sim.
+func (k *kernelBackend) close(s *Sim) error {
+	for i := range s.Chips {
+		if cl, ok := s.Chips[i].lines.(*cachedSysfsLines); ok {
+			cl.close()
+		}
+	}
+	switch {
+	case k.detach:
+		return nil
+	case k.opened:
+		// the banks may not be named as per setupConfigfs
+		return k.removeConfigfs(s.configfsPath)
+	default:
+		return k.cleanupConfigfs(s)
+	}
+}
+
+// sysfsPath returns the root of sysfs.
+func (k *kernelBackend) sysfsPath() string {
+	if len(k.sysfsRoot) == 0 {
+		return "/sys"
+	}
+	return k.sysfsRoot
+}
+
+// devPath returns the directory containing the gpiochip device nodes.
+func (k *kernelBackend) devPath() string {
+	if len(k.devRoot) == 0 {
+		return "/dev"
+	}
+	return k.devRoot
+}
+
+func (k *kernelBackend) readAttr(p, attr string) (string, error) {
+	data, err := k.fs.readFile(path.Join(p, attr))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (k *kernelBackend) writeAttr(p, attr, value string) error {
+	return k.fs.writeFile(path.Join(p, attr), []byte(value))
+}
+
+// cleanupConfigfs removes all the gpio-sim configurtation for the sim.
+//
+// All removals are attempted, even if some fail, and any failures are
+// returned in a TeardownError.
+// Parts of the configuration that do not exist are ignored.
+func (k *kernelBackend) cleanupConfigfs(s *Sim) error {
+	var terr TeardownError
+	// not strictly necessary to set live=0, but it can't hurt.
+	terr.add(k.writeAttr(s.configfsPath, "live", "0"))
+	for i, c := range s.Chips {
+		bankPath := path.Join(s.configfsPath, fmt.Sprintf("bank%d", i))
+		if _, err := k.fs.stat(bankPath); err != nil {
+			continue
+		}
+		for _, o := range sortedOffsets(c.cfg.Hogs) {
+			linePath := path.Join(bankPath, fmt.Sprintf("line%d", o))
+			terr.add(k.fs.remove(path.Join(linePath, "hog")))
+			terr.add(k.fs.remove(linePath))
+		}
+		for _, o := range sortedOffsets(c.cfg.Names) {
+			if _, ok := c.cfg.Hogs[o]; ok {
+				// already removed with the hog
+				continue
+			}
+			linePath := path.Join(bankPath, fmt.Sprintf("line%d", o))
+			terr.add(k.fs.remove(linePath))
+		}
+		terr.add(k.fs.remove(bankPath))
+	}
+	terr.add(k.fs.remove(s.configfsPath))
+	if len(terr.Busy) != 0 || len(terr.Other) != 0 {
+		return terr
+	}
+	return nil
+}
+
+// setupConfigfs constructs the gpio-sim configuration in configfs for the sim,
+// including each of the simulated chips.
+func (k *kernelBackend) setupConfigfs(s *Sim) error {
+	for i, c := range s.Chips {
+		bankPath := path.Join(s.configfsPath, fmt.Sprintf("bank%d", i))
+		if err := k.fs.mkdirAll(bankPath); err != nil {
+			return err
+		}
+		if err := k.writeAttr(bankPath, "label", c.cfg.Label); err != nil {
+			return err
+		}
+		if err := k.writeAttr(bankPath, "num_lines", fmt.Sprintf("%d", c.cfg.NumLines)); err != nil {
+			return err
+		}
+		for _, o := range sortedOffsets(c.cfg.Names) {
+			n := c.cfg.Names[o]
+			linePath := path.Join(bankPath, fmt.Sprintf("line%d", o))
+			if err := k.fs.mkdir(linePath); err != nil {
+				return err
+			}
+			if err := k.writeAttr(linePath, "name", n); err != nil {
+				return err
+			}
+		}
+		for _, o := range sortedOffsets(c.cfg.Hogs) {
+			h := c.cfg.Hogs[o]
+			hogPath := path.Join(bankPath, fmt.Sprintf("line%d", o), "hog")
+			if err := k.fs.mkdirAll(hogPath); err != nil {
+				return err
+			}
+			if err := k.writeAttr(hogPath, "name", h.Consumer); err != nil {
+				return err
+			}
+			if err := k.writeAttr(hogPath, "direction", hogDirectionToString(h.Direction)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findConfigfsPath finds the location of gpio-sim in the configfs.
+//
+// If the configfsRoot is set then only that location is checked.
+func (k *kernelBackend) findConfigfsPath() (string, error) {
+	if len(k.configfsRoot) != 0 {
+		configfs := path.Join(k.configfsRoot, "gpio-sim")
+		if _, err := k.fs.stat(configfs); err == nil {
+			return configfs, nil
+		}
+		return "", ErrModuleNotLoaded
+	}
+	return findConfigfsPath()
+}
+
+// newLines returns the access to the line attributes of the gpio-sim chip at
+// the given path in sysfs.
+func (k *kernelBackend) newLines(sysfsPath string) lineAttrs {
+	if k.cacheLineFiles {
+		return &cachedSysfsLines{path: sysfsPath, files: make(map[string]*os.File)}
+	}
+	return sysfsLines(sysfsPath)
+}
+
+// sysfsLines provides access to the line attributes of a gpio-sim chip.
+//
+// The value is the path to the chip in sysfs.
+type sysfsLines string
+
+// line returns the attributes of the line in sysfs.
+func (p sysfsLines) line(offset int) lineAttr {
+	return sysfsLine(path.Join(string(p), fmt.Sprintf("sim_gpio%d", offset)))
+}
+
+// sysfsLine provides access to the attributes of a gpio-sim line.
+//
+// The value is the path to the line in sysfs.
+type sysfsLine string
+
+// readAttr reads the given line attribute from sysfs
+func (p sysfsLine) readAttr(attr string) (string, error) {
+	return readAttr(string(p), attr)
+}
+
+// writeAttr writes the given line attribute to sysfs
+func (p sysfsLine) writeAttr(attr, value string) error {
+	return writeAttr(string(p), attr, value)
+}
+
+// cachedSysfsLines provides access to the line attributes of a gpio-sim chip,
+// keeping the attribute files open between accesses.
+//
+// The attributes are read and written at offset 0 using pread and pwrite,
+// which causes sysfs to regenerate or reparse the attribute each time.
+type cachedSysfsLines struct {
+	// The path to the chip in sysfs.
+	path string
+
+	mu sync.Mutex
+
+	// The open attribute files, keyed by path.
+	files map[string]*os.File
+}
+
+// line returns the attributes of the line in sysfs.
+func (p *cachedSysfsLines) line(offset int) lineAttr {
+	return cachedSysfsLine{p, path.Join(p.path, fmt.Sprintf("sim_gpio%d", offset))}
+}
+
+// file returns the open attribute file, opening it if necessary.
+//
+// Read-only attributes, such as "value", are opened read-only.
+func (p *cachedSysfsLines) file(name string) (*os.File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if f, ok := p.files[name]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrPermission) {
+		f, err = os.OpenFile(name, os.O_RDONLY, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.files[name] = f
+	return f, nil
+}
+
+// close closes all the open attribute files.
+//
+// Any subsequent access reopens the files.
+func (p *cachedSysfsLines) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, f := range p.files {
+		f.Close()
+	}
+	p.files = make(map[string]*os.File)
+}
+
+// cachedSysfsLine provides access to the attributes of a gpio-sim line, using
+// the files cached by the chip.
+type cachedSysfsLine struct {
+	lines *cachedSysfsLines
+
+	// The path to the line in sysfs.
+	path string
+}
+
+// readAttr reads the given line attribute from sysfs
+func (l cachedSysfsLine) readAttr(attr string) (string, error) {
+	f, err := l.lines.file(path.Join(l.path, attr))
+	if err != nil {
+		return "", err
+	}
+	// sysfs attributes are limited to a page, but line attributes are short
+	buf := make([]byte, 64)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf[:n])), nil
+}
+
+// writeAttr writes the given line attribute to sysfs
+func (l cachedSysfsLine) writeAttr(attr, value string) error {
+	f, err := l.lines.file(path.Join(l.path, attr))
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt([]byte(value), 0)
+	return err
+}
+
+// sysFS provides the file system operations used by the kernel backend to
+// access configfs, sysfs and dev.
+type sysFS interface {
+	mkdir(p string) error
+	mkdirAll(p string) error
+	remove(p string) error
+	readDir(p string) ([]fs.DirEntry, error)
+	readFile(p string) ([]byte, error)
+	writeFile(p string, data []byte) error
+	stat(p string) (fs.FileInfo, error)
+	lstat(p string) (fs.FileInfo, error)
+
+	// usedLines returns the offsets of the lines of the gpiochip device that
+	// are in use, either requested by userspace or hogged.
+	usedLines(p string) ([]int, error)
+}
+
+// osFS performs the sysFS operations on the os file system.
+type osFS struct{}
+
+func (osFS) mkdir(p string) error {
+	return os.Mkdir(p, 0755)
+}
+
+func (osFS) mkdirAll(p string) error {
+	return os.MkdirAll(p, 0755)
+}
+
+func (osFS) remove(p string) error {
+	return os.Remove(p)
+}
+
+func (osFS) readDir(p string) ([]fs.DirEntry, error) {
+	return os.ReadDir(p)
+}
+
+func (osFS) readFile(p string) ([]byte, error) {
+	return os.ReadFile(p)
+}
+
+func (osFS) writeFile(p string, data []byte) error {
+	return os.WriteFile(p, data, 0666)
+}
+
+func (osFS) stat(p string) (fs.FileInfo, error) {
+	return os.Stat(p)
+}
+
+func (osFS) lstat(p string) (fs.FileInfo, error) {
+	return os.Lstat(p)
+}
+
+func (osFS) usedLines(p string) ([]int, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ci, err := uapi.GetChipInfo(f.Fd())
+	if err != nil {
+		return nil, err
+	}
+	var used []int
+	for o := 0; o < int(ci.Lines); o++ {
+		li, err := uapi.GetLineInfoV2(f.Fd(), o)
+		if err != nil {
+			return nil, err
+		}
+		if li.Flags.IsUsed() {
+			used = append(used, o)
+		}
+	}
+	return used, nil
+}
+
+// configfsMountPoint finds the location where configfs is mounted in the file system.
+//
+// If no mountpoint is found, attempts to mount it in the usual "/sys/kernel/config".
+func configfsMountPoint() (string, error) {
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		words := strings.Fields(scanner.Text())
+		if len(words) >= 6 && words[2] == "configfs" {
+			return words[1], nil
+		}
+	}
+	// not mounted, so try to mount
+	configfs := "/sys/kernel/config"
+	cmd := exec.Command("mount", "-t", "configfs", "configfs", configfs)
+	if err = cmd.Run(); err == nil {
+		return configfs, nil
+	}
+	return "", ErrConfigfsNotMounted
+}
+
+// findConfigfsPath finds the location of gpio-sim in theconfigfs.
+func findConfigfsPath() (string, error) {
+	configfs := "/sys/kernel/config/gpio-sim"
+	if _, err := os.Stat(configfs); err == nil {
+		return configfs, nil
+	}
+	// try loading gpio-sim module
+	cmd := exec.Command("modprobe", "gpio-sim")
+	if err := cmd.Run(); err == nil {
+		if _, err := os.Stat(configfs); err == nil {
+			return configfs, nil
+		}
+	}
+	// check mountpoints in case configfs is mounted somewhere unusual
+	configfs, err := configfsMountPoint()
+	if err != nil {
+		return "", err
+	}
+	configfs = path.Join(configfs, "gpio-sim")
+	if _, err := os.Stat(configfs); err == nil {
+		return configfs, nil
+	}
+	return "", ErrModuleNotLoaded
+}
@@ -0,0 +1,807 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// stubKernel emulates the behaviour of gpio-sim in configfs, sysfs and dev
+// within a temporary directory tree.
+//
+// Taking a sim live creates the dev_name and chip_name attributes, the chips
+// in sysfs and the device nodes in dev.
+// Creating a directory in configfs creates the default attributes within it.
+// The device nodes are regular files containing the device number, which the
+// stub reports as character devices.  Their creation may be delayed by
+// devNodeDelay, or suppressed by noDevNode.  Removing a directory from configfs
+// also removes the attribute files within it, and fails with EBUSY if the
+// sim is live.
+//
+// Failures can be injected into any operation by adding the error to fail,
+// keyed by the operation and the path relative to the root of the tree,
+// e.g. "write config/gpio-sim/sim/bank0/label".
+// Each injected failure is only returned once.
+//
+// The lines of chips reported as in use are set in used, keyed by chip name.
+//
+// The key of every operation performed is recorded in ops.
+type stubKernel struct {
+	osFS
+
+	root     string
+	configfs string
+	sysfs    string
+	dev      string
+
+	devCount  int
+	chipCount int
+
+	devNodeDelay time.Duration
+	noDevNode    bool
+
+	used map[string][]int
+
+	fail map[string]error
+	ops  []string
+}
+
+func newStubKernel(t *testing.T) *stubKernel {
+	root := t.TempDir()
+	k := stubKernel{
+		root:     root,
+		configfs: path.Join(root, "config"),
+		sysfs:    path.Join(root, "sys"),
+		dev:      path.Join(root, "dev"),
+		used:     make(map[string][]int),
+		fail:     make(map[string]error),
+	}
+	for _, d := range []string{path.Join(k.configfs, "gpio-sim"), k.sysfs, k.dev} {
+		require.Nil(t, os.MkdirAll(d, 0755))
+	}
+	return &k
+}
+
+// withFS is an option that overrides the file system used by the kernel backend.
+type withFS struct {
+	sysFS
+}
+
+func (o withFS) applySimOption(b *builder) {
+	b.kernel.fs = o.sysFS
+}
+
+func (o withFS) applyListSimsOption(k *kernelBackend) {
+	k.fs = o.sysFS
+}
+
+func (o withFS) applyOpenSimOption(op *opener) {
+	op.kernel.fs = o.sysFS
+}
+
+// options returns the options required to build a sim using the stub.
+func (k *stubKernel) options(options ...NewSimOption) []NewSimOption {
+	return append([]NewSimOption{
+		WithConfigfsRoot(k.configfs),
+		WithSysfsRoot(k.sysfs),
+		WithDevRoot(k.dev),
+		withFS{k},
+	}, options...)
+}
+
+// residue returns any sims remaining in configfs, and any files remaining
+// in the sysfs or dev trees.
+func (k *stubKernel) residue() []string {
+	var files []string
+	entries, _ := os.ReadDir(path.Join(k.configfs, "gpio-sim"))
+	for _, e := range entries {
+		files = append(files, path.Join(k.configfs, "gpio-sim", e.Name()))
+	}
+	for _, d := range []string{k.sysfs, k.dev} {
+		filepath.Walk(d, func(p string, info fs.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				files = append(files, p)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+func (k *stubKernel) injected(op, p string) error {
+	rel, _ := filepath.Rel(k.root, p)
+	key := op + " " + rel
+	k.ops = append(k.ops, key)
+	err := k.fail[key]
+	delete(k.fail, key)
+	return err
+}
+
+func (k *stubKernel) mkdir(p string) error {
+	if err := k.injected("mkdir", p); err != nil {
+		return err
+	}
+	if err := k.osFS.mkdir(p); err != nil {
+		return err
+	}
+	k.createAttrs(p)
+	return nil
+}
+
+func (k *stubKernel) mkdirAll(p string) error {
+	if err := k.injected("mkdir", p); err != nil {
+		return err
+	}
+	if err := k.osFS.mkdirAll(p); err != nil {
+		return err
+	}
+	for d := p; path.Dir(d) != k.configfs && d != "/"; d = path.Dir(d) {
+		k.createAttrs(d)
+	}
+	return nil
+}
+
+// createAttrs creates the default attributes for a configfs directory,
+// if they do not already exist.
+func (k *stubKernel) createAttrs(p string) {
+	rel, err := filepath.Rel(path.Join(k.configfs, "gpio-sim"), p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return
+	}
+	var attrs map[string]string
+	switch strings.Count(rel, "/") {
+	case 0:
+		attrs = map[string]string{"live": "0"}
+	case 1:
+		attrs = map[string]string{"label": "", "num_lines": "1"}
+	case 2:
+		attrs = map[string]string{"name": ""}
+	case 3:
+		attrs = map[string]string{"name": "", "direction": "input"}
+	}
+	for a, v := range attrs {
+		ap := path.Join(p, a)
+		if _, err := os.Stat(ap); err != nil {
+			os.WriteFile(ap, []byte(v+"\n"), 0644)
+		}
+	}
+}
+
+// remove emulates rmdir in configfs, which implicitly removes attributes.
+func (k *stubKernel) remove(p string) error {
+	if err := k.injected("remove", p); err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(path.Join(k.configfs, "gpio-sim"), p); err == nil {
+		simPath := path.Join(k.configfs, "gpio-sim", strings.Split(rel, "/")[0])
+		if _, err := os.Stat(path.Join(simPath, "dev_name")); err == nil {
+			return &fs.PathError{Op: "remove", Path: p, Err: syscall.EBUSY}
+		}
+	}
+	if entries, err := os.ReadDir(p); err == nil {
+		for _, e := range entries {
+			if !e.IsDir() {
+				os.Remove(path.Join(p, e.Name()))
+			}
+		}
+	}
+	return k.osFS.remove(p)
+}
+
+func (k *stubKernel) readDir(p string) ([]fs.DirEntry, error) {
+	if err := k.injected("readdir", p); err != nil {
+		return nil, err
+	}
+	return k.osFS.readDir(p)
+}
+
+func (k *stubKernel) readFile(p string) ([]byte, error) {
+	if err := k.injected("read", p); err != nil {
+		return nil, err
+	}
+	return k.osFS.readFile(p)
+}
+
+func (k *stubKernel) writeFile(p string, data []byte) error {
+	if err := k.injected("write", p); err != nil {
+		return err
+	}
+	if path.Base(p) == "live" && path.Dir(path.Dir(p)) == path.Join(k.configfs, "gpio-sim") {
+		var err error
+		if strings.TrimSpace(string(data)) == "1" {
+			err = k.goLive(path.Dir(p))
+		} else {
+			err = k.goDead(path.Dir(p))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return k.osFS.writeFile(p, data)
+}
+
+func (k *stubKernel) stat(p string) (fs.FileInfo, error) {
+	if err := k.injected("stat", p); err != nil {
+		return nil, err
+	}
+	return k.osFS.stat(p)
+}
+
+func (k *stubKernel) lstat(p string) (fs.FileInfo, error) {
+	if err := k.injected("lstat", p); err != nil {
+		return nil, err
+	}
+	fi, err := k.osFS.lstat(p)
+	if err != nil || path.Dir(p) != k.dev || !fi.Mode().IsRegular() {
+		return fi, err
+	}
+	var major, minor uint32
+	data, _ := os.ReadFile(p)
+	if _, err := fmt.Sscanf(string(data), "%d:%d", &major, &minor); err != nil {
+		return fi, nil
+	}
+	return stubDevNode{fi, unix.Mkdev(major, minor)}, nil
+}
+
+func (k *stubKernel) usedLines(p string) ([]int, error) {
+	if err := k.injected("used", p); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(p); err != nil {
+		return nil, err
+	}
+	return k.used[path.Base(p)], nil
+}
+
+// stubDevNode reports a regular file as a character device.
+type stubDevNode struct {
+	fs.FileInfo
+	rdev uint64
+}
+
+func (n stubDevNode) Mode() fs.FileMode {
+	return n.FileInfo.Mode() | fs.ModeDevice | fs.ModeCharDevice
+}
+
+func (n stubDevNode) Sys() any {
+	return &syscall.Stat_t{Rdev: n.rdev}
+}
+
+// banks returns the paths of the banks of the sim, in bank order.
+func (k *stubKernel) banks(simPath string) []string {
+	entries, _ := os.ReadDir(simPath)
+	var banks []string
+	for _, e := range entries {
+		if e.IsDir() {
+			banks = append(banks, path.Join(simPath, e.Name()))
+		}
+	}
+	sort.Slice(banks, func(i, j int) bool {
+		return naturalLess(banks[i], banks[j])
+	})
+	return banks
+}
+
+// goLive emulates gpio-sim creating the chips for the sim.
+func (k *stubKernel) goLive(simPath string) error {
+	devName := fmt.Sprintf("gpio-sim.%d", k.devCount)
+	k.devCount++
+	for _, bankPath := range k.banks(simPath) {
+		var numLines int
+		data, _ := os.ReadFile(path.Join(bankPath, "num_lines"))
+		fmt.Sscanf(string(data), "%d", &numLines)
+		if numLines <= 0 {
+			return syscall.EINVAL
+		}
+		chipName := fmt.Sprintf("gpiochip%d", k.chipCount)
+		dev := fmt.Sprintf("254:%d", k.chipCount)
+		k.chipCount++
+		chipPath := path.Join(k.sysfs, "devices/platform", devName, chipName)
+		if err := os.MkdirAll(chipPath, 0755); err != nil {
+			return err
+		}
+		os.WriteFile(path.Join(chipPath, "dev"), []byte(dev+"\n"), 0644)
+		for o := 0; o < numLines; o++ {
+			linePath := path.Join(chipPath, fmt.Sprintf("sim_gpio%d", o))
+			if err := os.MkdirAll(linePath, 0755); err != nil {
+				return err
+			}
+			os.WriteFile(path.Join(linePath, "pull"), []byte("pull-down\n"), 0644)
+			os.WriteFile(path.Join(linePath, "value"), []byte("0\n"), 0644)
+		}
+		devPath := path.Join(k.dev, chipName)
+		switch {
+		case k.noDevNode:
+		case k.devNodeDelay != 0:
+			go func() {
+				// create atomically, as per mknod
+				time.Sleep(k.devNodeDelay)
+				os.WriteFile(devPath+".tmp", []byte(dev), 0644)
+				os.Rename(devPath+".tmp", devPath)
+			}()
+		default:
+			if err := os.WriteFile(devPath, []byte(dev), 0644); err != nil {
+				return err
+			}
+		}
+		os.WriteFile(path.Join(bankPath, "chip_name"), []byte(chipName+"\n"), 0644)
+	}
+	return os.WriteFile(path.Join(simPath, "dev_name"), []byte(devName+"\n"), 0644)
+}
+
+// goDead emulates gpio-sim removing the chips for the sim.
+func (k *stubKernel) goDead(simPath string) error {
+	data, err := os.ReadFile(path.Join(simPath, "dev_name"))
+	if err != nil {
+		// not live
+		return nil
+	}
+	devName := strings.TrimSpace(string(data))
+	for _, bankPath := range k.banks(simPath) {
+		if data, err := os.ReadFile(path.Join(bankPath, "chip_name")); err == nil {
+			os.Remove(path.Join(k.dev, strings.TrimSpace(string(data))))
+		}
+		os.Remove(path.Join(bankPath, "chip_name"))
+	}
+	os.RemoveAll(path.Join(k.sysfs, "devices/platform", devName))
+	return os.Remove(path.Join(simPath, "dev_name"))
+}
+
+func stubBanks() []NewSimOption {
+	return []NewSimOption{
+		WithBank(NewBank("left", 8,
+			WithNamedLine(3, "LED0"),
+			WithNamedLine(5, "BUTTON1"),
+			WithHoggedLine(2, "piggy", HogDirectionOutputLow),
+		)),
+		WithBank(NewBank("right", 42,
+			WithNamedLine(3, "BUTTON2"),
+			WithHoggedLine(3, "hogster", HogDirectionOutputHigh),
+			WithHoggedLine(9, "piggy", HogDirectionInput),
+		)),
+	}
+}
+
+func TestStubNewSim(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(stubBanks()...)...)
+	require.Nil(t, err)
+	require.NotNil(t, s)
+	defer s.Close()
+
+	require.Equal(t, 2, len(s.Chips))
+	c := &s.Chips[0]
+	assert.Equal(t, "gpiochip0", c.ChipName())
+	assert.Equal(t, path.Join(k.dev, "gpiochip0"), c.DevPath())
+	assert.Equal(t, path.Join(k.sysfs, "devices/platform/gpio-sim.0/gpiochip0"), c.sysfsPath)
+	assert.Equal(t, path.Join(k.configfs, "gpio-sim", s.Name, "bank0"), c.configfsPath)
+	assert.Equal(t, path.Join(k.dev, "gpiochip1"), s.Chips[1].DevPath())
+
+	// configfs
+	label, err := os.ReadFile(path.Join(c.configfsPath, "label"))
+	assert.Nil(t, err)
+	assert.Equal(t, "left", string(label))
+	name, err := os.ReadFile(path.Join(s.Chips[1].configfsPath, "line3", "name"))
+	assert.Nil(t, err)
+	assert.Equal(t, "BUTTON2", string(name))
+	dir, err := os.ReadFile(path.Join(s.Chips[1].configfsPath, "line3", "hog", "direction"))
+	assert.Nil(t, err)
+	assert.Equal(t, "output-high", string(dir))
+
+	// sysfs
+	err = c.Pullup(4)
+	assert.Nil(t, err)
+	pull, err := c.Pull(4)
+	assert.Nil(t, err)
+	assert.Equal(t, LevelActive, pull)
+	level, err := c.Level(4)
+	assert.Nil(t, err)
+	assert.Equal(t, LevelInactive, level)
+
+	s.Close()
+	assert.Empty(t, k.residue())
+}
+
+func TestStubNoGpioSim(t *testing.T) {
+	k := newStubKernel(t)
+	require.Nil(t, os.Remove(path.Join(k.configfs, "gpio-sim")))
+	s, err := NewSim(k.options(stubBanks()...)...)
+	assert.ErrorIs(t, err, ErrModuleNotLoaded)
+	assert.Nil(t, s)
+}
+
+func TestStubPermissionDenied(t *testing.T) {
+	k := newStubKernel(t)
+	k.fail["mkdir config/gpio-sim/sim/bank0"] = syscall.EACCES
+	s, err := NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.Nil(t, s)
+}
+
+func TestStubNameExists(t *testing.T) {
+	k := newStubKernel(t)
+	require.Nil(t, os.Mkdir(path.Join(k.configfs, "gpio-sim", "dup"), 0755))
+	s, err := NewSim(k.options(append(stubBanks(), WithName("dup"))...)...)
+	assert.ErrorIs(t, err, ErrSimExists)
+	var se SimExistsError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, "dup", se.Name)
+	assert.Nil(t, s)
+}
+
+func TestStubSymlinkMasking(t *testing.T) {
+	k := newStubKernel(t)
+	require.Nil(t, os.Symlink("/dev/null", path.Join(k.dev, "gpiochip1")))
+	s, err := NewSim(k.options(stubBanks()...)...)
+	assert.ErrorIs(t, err, ErrSymlinkMasking)
+	var se SymlinkMaskingError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, path.Join(k.dev, "gpiochip1"), se.Path)
+	assert.Equal(t, "gpiochip1", se.ChipName)
+	assert.Nil(t, s)
+	assert.Empty(t, k.residue())
+}
+
+func TestStubDevNodeDelayed(t *testing.T) {
+	k := newStubKernel(t)
+	k.devNodeDelay = 50 * time.Millisecond
+	start := time.Now()
+	s, err := NewSim(k.options(stubBanks()...)...)
+	require.Nil(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), k.devNodeDelay)
+	assert.FileExists(t, s.Chips[0].DevPath())
+	assert.FileExists(t, s.Chips[1].DevPath())
+	assert.Nil(t, s.Close())
+	assert.Empty(t, k.residue())
+}
+
+func TestStubDevNodeTimeout(t *testing.T) {
+	k := newStubKernel(t)
+	k.noDevNode = true
+	s, err := NewSim(k.options(append(stubBanks(), WithDevNodeTimeout(50*time.Millisecond))...)...)
+	assert.Nil(t, s)
+	assert.ErrorIs(t, err, ErrDevNodeTimeout)
+	var te DevNodeTimeoutError
+	require.ErrorAs(t, err, &te)
+	assert.Equal(t, path.Join(k.dev, "gpiochip0"), te.Path)
+	assert.Equal(t, 50*time.Millisecond, te.Timeout)
+	assert.Empty(t, k.residue())
+}
+
+func TestStubDevNodeMismatch(t *testing.T) {
+	k := newStubKernel(t)
+	k.noDevNode = true
+	stale := path.Join(k.dev, "gpiochip0")
+	require.Nil(t, os.WriteFile(stale, []byte("254:7"), 0644))
+	s, err := NewSim(k.options(append(stubBanks(), WithDevNodeTimeout(50*time.Millisecond))...)...)
+	assert.Nil(t, s)
+	assert.ErrorIs(t, err, ErrDevNodeMismatch)
+	var me DevNodeMismatchError
+	require.ErrorAs(t, err, &me)
+	assert.Equal(t, stale, me.Path)
+	assert.Equal(t, "254:0", me.Want)
+	assert.Equal(t, "254:7", me.Got)
+
+	// not a character device
+	stale = path.Join(k.dev, "gpiochip2")
+	require.Nil(t, os.WriteFile(stale, nil, 0644))
+	s, err = NewSim(k.options(append(stubBanks(), WithDevNodeTimeout(50*time.Millisecond))...)...)
+	assert.Nil(t, s)
+	require.ErrorAs(t, err, &me)
+	assert.Equal(t, "254:2", me.Want)
+	assert.Equal(t, "not a character device", me.Got)
+}
+
+func TestStubDevNodeReplaced(t *testing.T) {
+	k := newStubKernel(t)
+	k.devNodeDelay = 50 * time.Millisecond
+	require.Nil(t, os.WriteFile(path.Join(k.dev, "gpiochip0"), []byte("254:7"), 0644))
+	s, err := NewSim(k.options(stubBanks()...)...)
+	require.Nil(t, err)
+	assert.Nil(t, s.Close())
+	assert.Empty(t, k.residue())
+}
+
+func TestStubInvalidBank(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(
+		WithBank(NewBank("left", 8)),
+		WithBank(NewBank("right", 8, WithNamedLine(9, "nine"))),
+	)...)
+	assert.ErrorIs(t, err, ErrInvalidBank)
+	assert.Nil(t, s)
+	assert.Empty(t, k.residue())
+}
+
+func TestStubSetupConfigfsError(t *testing.T) {
+	fail := []string{
+		"mkdir config/gpio-sim/sim/bank0",
+		"write config/gpio-sim/sim/bank0/label",
+		"write config/gpio-sim/sim/bank0/num_lines",
+		"mkdir config/gpio-sim/sim/bank1",
+		"mkdir config/gpio-sim/sim/bank0/line3",
+		"write config/gpio-sim/sim/bank0/line3/name",
+		"mkdir config/gpio-sim/sim/bank1/line9/hog",
+		"write config/gpio-sim/sim/bank1/line9/hog/name",
+		"write config/gpio-sim/sim/bank1/line9/hog/direction",
+		"write config/gpio-sim/sim/live",
+		"read config/gpio-sim/sim/dev_name",
+		"read config/gpio-sim/sim/bank1/chip_name",
+	}
+	for _, f := range fail {
+		tf := func(t *testing.T) {
+			k := newStubKernel(t)
+			k.fail[f] = syscall.EIO
+			s, err := NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+			assert.ErrorIs(t, err, syscall.EIO)
+			assert.Nil(t, s)
+			assert.Empty(t, k.residue())
+		}
+		t.Run(f, tf)
+	}
+}
+
+func TestStubRollback(t *testing.T) {
+	// record the steps performed by a successful NewSim
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+	require.Nil(t, err)
+	steps := k.ops
+	require.Nil(t, s.Close())
+
+	for _, step := range steps {
+		tf := func(t *testing.T) {
+			k := newStubKernel(t)
+			k.fail[step] = syscall.EIO
+			s, err := NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+			if err == nil {
+				// failure was benign, e.g. stat confirming the sim does not exist
+				require.NotNil(t, s)
+				assert.Nil(t, s.Close())
+			} else {
+				assert.Nil(t, s)
+			}
+			assert.Empty(t, k.residue())
+		}
+		t.Run(step, tf)
+	}
+}
+
+func TestStubRollbackFailure(t *testing.T) {
+	k := newStubKernel(t)
+	k.fail["lstat dev/gpiochip1"] = syscall.EINVAL
+	k.fail["remove config/gpio-sim/sim"] = syscall.EIO
+	s, err := NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+	assert.Nil(t, s)
+	assert.ErrorIs(t, err, syscall.EINVAL)
+	assert.Contains(t, err.Error(), "rollback failed")
+	assert.Equal(t, []string{path.Join(k.configfs, "gpio-sim", "sim")}, k.residue())
+}
+
+func TestStubCleanupConfigfsError(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+	require.Nil(t, err)
+	defer s.Close()
+
+	// sim remains live so all removals are busy
+	k.fail["write config/gpio-sim/sim/live"] = syscall.EIO
+	kb, ok := s.backend.(*kernelBackend)
+	require.True(t, ok)
+	err = kb.cleanupConfigfs(s)
+	var te TeardownError
+	require.ErrorAs(t, err, &te)
+	assert.ErrorIs(t, err, ErrBusy)
+	require.Equal(t, 1, len(te.Other))
+	assert.ErrorIs(t, te.Other[0], syscall.EIO)
+	// 3 hogs + 3 hogged lines + 2 named lines + 2 banks + 1 sim
+	assert.Equal(t, 11, len(te.Busy))
+
+	err = kb.cleanupConfigfs(s)
+	assert.Nil(t, err)
+	assert.Empty(t, k.residue())
+}
+
+func TestStubClose(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+	require.Nil(t, err)
+
+	k.fail["remove config/gpio-sim/sim/bank1/line9/hog"] = syscall.EBUSY
+	k.fail["remove config/gpio-sim/sim/bank0/line5"] = syscall.EIO
+	err = s.Close()
+	var te TeardownError
+	require.ErrorAs(t, err, &te)
+	assert.ErrorIs(t, err, ErrBusy)
+	require.Equal(t, 1, len(te.Busy))
+	assert.ErrorIs(t, te.Busy[0], syscall.EBUSY)
+	// the remaining line5 prevents removing bank0, and the remaining hog
+	// prevents removing line9 and bank1, and so the sim.
+	require.Equal(t, 5, len(te.Other))
+	assert.ErrorIs(t, te.Other[0], syscall.EIO)
+	assert.ErrorIs(t, te.Other[1], syscall.ENOTEMPTY)
+	assert.NotEmpty(t, k.residue())
+	assert.Equal(t, 2, len(s.Chips))
+
+	err = s.Close()
+	assert.Nil(t, err)
+	assert.Empty(t, k.residue())
+	assert.Nil(t, s.Chips)
+
+	// already closed
+	err = s.Close()
+	assert.Nil(t, err)
+}
+
+func TestStubCloseWithTimeout(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+	require.Nil(t, err)
+
+	k.fail["remove config/gpio-sim/sim"] = syscall.EBUSY
+	err = s.CloseWithTimeout(time.Second)
+	assert.Nil(t, err)
+	assert.Empty(t, k.residue())
+
+	// other failures are not retried
+	s, err = NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+	require.Nil(t, err)
+	k.fail["remove config/gpio-sim/sim"] = syscall.EIO
+	err = s.CloseWithTimeout(time.Second)
+	assert.NotErrorIs(t, err, ErrBusy)
+	var te TeardownError
+	require.ErrorAs(t, err, &te)
+	require.Equal(t, 1, len(te.Other))
+	assert.ErrorIs(t, te.Other[0], syscall.EIO)
+	assert.NotEmpty(t, k.residue())
+	err = s.Close()
+	assert.Nil(t, err)
+
+	// the failures are matched
+	s, err = NewSim(k.options(append(stubBanks(), WithName("sim"))...)...)
+	require.Nil(t, err)
+	k.fail["remove config/gpio-sim/sim"] = syscall.EACCES
+	err = s.Close()
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.ErrorIs(t, err, syscall.EACCES)
+	assert.NotErrorIs(t, err, ErrBusy)
+	err = s.Close()
+	assert.Nil(t, err)
+}
+
+func TestStubCachedLineFiles(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(append(stubBanks(), WithCachedLineFiles())...)...)
+	require.Nil(t, err)
+	defer s.Close()
+
+	c := &s.Chips[0]
+	cl, ok := c.lines.(*cachedSysfsLines)
+	require.True(t, ok)
+	pullPath := path.Join(c.sysfsPath, "sim_gpio4", "pull")
+
+	pull, err := c.Pull(4)
+	assert.Nil(t, err)
+	assert.Equal(t, LevelInactive, pull)
+	level, err := c.Level(4)
+	assert.Nil(t, err)
+	assert.Equal(t, LevelInactive, level)
+	assert.Equal(t, 2, len(cl.files))
+
+	// the file is reread on each access
+	require.Nil(t, os.WriteFile(pullPath, []byte("pull-up\n"), 0644))
+	pull, err = c.Pull(4)
+	assert.Nil(t, err)
+	assert.Equal(t, LevelActive, pull)
+
+	// writes go to the cached file.
+	// sysfs reparses the whole write, but regular files are not truncated,
+	// so start from empty.
+	require.Nil(t, os.Truncate(pullPath, 0))
+	assert.Nil(t, c.Pulldown(4))
+	data, err := os.ReadFile(pullPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "pull-down", string(data))
+	assert.Equal(t, 2, len(cl.files))
+
+	assert.Nil(t, s.Close())
+	assert.Empty(t, cl.files)
+	assert.Empty(t, k.residue())
+}
+
+func TestStubWatchLevel(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(append(stubBanks(), WithLevelPollInterval(time.Millisecond))...)...)
+	require.Nil(t, err)
+	defer s.Close()
+
+	c := &s.Chips[0]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := c.WatchLevel(ctx, 4)
+	require.Nil(t, err)
+
+	// regular files do not notify, so falls back to polling
+	valuePath := path.Join(c.sysfsPath, "sim_gpio4", "value")
+	require.Nil(t, os.WriteFile(valuePath, []byte("1\n"), 0644))
+	select {
+	case evt := <-ch:
+		assert.Equal(t, 4, evt.Offset)
+		assert.Equal(t, LevelActive, evt.Level)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no event")
+	}
+
+	// reading fails once the sim is closed
+	require.Nil(t, s.Close())
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		assert.Fail(t, "channel not closed")
+	}
+}
+
+// nopDevice attaches to a line and ignores its changes.
+type nopDevice struct{}
+
+func (nopDevice) Lines() []string                     { return []string{"LED0"} }
+func (nopDevice) Attach([]Line) error                 { return nil }
+func (nopDevice) LevelChanged(Line, LevelEvent) error { return nil }
+func (nopDevice) Detach() error                       { return nil }
+
+func TestStubConnections(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(append(stubBanks(), WithLevelPollInterval(time.Millisecond))...)...)
+	require.Nil(t, err)
+	defer s.Close()
+
+	c := &s.Chips[0]
+	from, err := c.Line(3)
+	require.Nil(t, err)
+	to, err := c.Line(5)
+	require.Nil(t, err)
+
+	// connections closed on their own are removed from the sim
+	for i := 0; i < 3; i++ {
+		w, err := s.Wire(from, to)
+		require.Nil(t, err)
+		ad, err := s.AttachDevice(nopDevice{})
+		require.Nil(t, err)
+		assert.Equal(t, 2, s.connections.len())
+		assert.Nil(t, w.Close())
+		assert.Nil(t, ad.Close())
+		assert.Equal(t, 0, s.connections.len())
+	}
+
+	// and the remainder are closed with the sim
+	w, err := s.Wire(from, to)
+	require.Nil(t, err)
+	ad, err := s.AttachDevice(nopDevice{})
+	require.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Equal(t, 1, s.connections.len())
+	require.Nil(t, s.Close())
+	assert.Equal(t, 0, s.connections.len())
+	select {
+	case <-ad.Done():
+	case <-time.After(time.Second):
+		assert.Fail(t, "device not detached")
+	}
+}
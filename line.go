@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Line provides the interface to a simulated line.
+//
+// A Line is obtained from Chip.Line, or by name using FindLine, which check
+// the offset is in range, so the Line methods do not need to.
+// The zero value is not a valid Line.
+type Line struct {
+	// The chip containing the line.
+	chip *Chip
+
+	// The offset of the line on the chip.
+	offset int
+
+	// The attributes of the line provided by the backend.
+	attrs lineAttr
+}
+
+// Chip returns the chip containing the line.
+func (l Line) Chip() *Chip {
+	return l.chip
+}
+
+// Offset returns the offset of the line on the chip.
+func (l Line) Offset() int {
+	return l.offset
+}
+
+// Name returns the name of the line, or an empty string if the line is not
+// named.
+func (l Line) Name() string {
+	return l.chip.cfg.Names[l.offset]
+}
+
+// Hog returns the details of the hog on the line, and true if the line is
+// hogged.
+func (l Line) Hog() (Hog, bool) {
+	h, ok := l.chip.cfg.Hogs[l.offset]
+	return h, ok
+}
+
+// Level returns the level the line is being pulled to.
+//
+// If the line is requested as an output then this is the level userspace is
+// driving it to, and otherwise there is little point calling this method -
+// you probably should be calling Pull instead.
+func (l Line) Level() (int, error) {
+	v, err := l.attrs.readAttr("value")
+	if err == nil {
+		if v == "0" {
+			return LevelInactive, nil
+		}
+		if v == "1" {
+			return LevelActive, nil
+		}
+		err = errors.Errorf("unexpected level value: %s", v)
+	}
+	return LevelInactive, err
+}
+
+// Pull returns the current the pull of the line.
+func (l Line) Pull() (int, error) {
+	v, err := l.attrs.readAttr("pull")
+	if err == nil {
+		if v == "pull-down" {
+			return LevelInactive, nil
+		}
+		if v == "pull-up" {
+			return LevelActive, nil
+		}
+		err = errors.Errorf("unexpected pull value: %s", v)
+	}
+	return LevelInactive, err
+}
+
+// Pulldown sets the pull of the line to pull-down.
+func (l Line) Pulldown() error {
+	return l.SetPull(LevelInactive)
+}
+
+// Pullup sets the pull of the line to pull-up.
+func (l Line) Pullup() error {
+	return l.SetPull(LevelActive)
+}
+
+// SetPull sets the pull of the line.
+func (l Line) SetPull(level int) error {
+	p := "pull-down"
+	if level == LevelActive {
+		p = "pull-up"
+	}
+	return l.attrs.writeAttr("pull", p)
+}
+
+// Toggle flips the pull of the line.
+//
+// If it was pull-up it becomes pull-down, and vice versa.
+func (l Line) Toggle() error {
+	p, err := l.Pull()
+	if err != nil {
+		return err
+	}
+	if p == 0 {
+		p = 1
+	} else {
+		p = 0
+	}
+	return l.SetPull(p)
+}
+
+// uniqueLine returns the only line found with the given name.
+func uniqueLine(name string, lines []Line) (Line, error) {
+	switch len(lines) {
+	case 0:
+		return Line{}, LineNotFoundError{name}
+	case 1:
+		return lines[0], nil
+	default:
+		return Line{}, AmbiguousLineError{name, lines}
+	}
+}
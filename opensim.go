@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OpenSimOption defines the interface required to provide an option to OpenSim.
+type OpenSimOption interface {
+	applyOpenSimOption(*opener)
+}
+
+// opener contains the information required to open an existing sim.
+type opener struct {
+	kernel kernelBackend
+
+	// The period between checks of the levels of watched lines.
+	levelPollInterval time.Duration
+}
+
+// OpenSim attaches to an existing live sim, such as one created by another
+// process or by a script.
+//
+// The Chips are reconstructed from the configuration of the sim in configfs,
+// in the order of the banks, sorted by name.
+//
+// By default, closing the returned Sim only detaches from the sim, leaving it
+// in place.  Use the [WithTeardownOnClose] option to remove the sim on Close.
+//
+// The other available options are [WithConfigfsRoot], [WithSysfsRoot],
+// [WithDevRoot], [WithCachedLineFiles] and [WithLevelPollInterval].
+func OpenSim(name string, options ...OpenSimOption) (*Sim, error) {
+	o := opener{kernel: kernelBackend{fs: osFS{}, opened: true, detach: true}}
+	for _, opt := range options {
+		opt.applyOpenSimOption(&o)
+	}
+	k := o.kernel
+	configfs, err := k.findConfigfsPath()
+	if err != nil {
+		return nil, err
+	}
+	s := Sim{
+		Name:         name,
+		configfsPath: path.Join(configfs, name),
+		backend:      &k,
+		connections:  &connectionSet{},
+	}
+	if _, err := k.fs.stat(s.configfsPath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, SimNotFoundError{name}
+		}
+		return nil, err
+	}
+	if live, err := k.readAttr(s.configfsPath, "live"); err != nil || live != "1" {
+		return nil, errors.Errorf("sim '%s' is not live", name)
+	}
+	devName, err := k.readAttr(s.configfsPath, "dev_name")
+	if err != nil {
+		return nil, err
+	}
+	banks, err := k.dirs(s.configfsPath, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, bank := range banks {
+		c := Chip{
+			configfsPath:      path.Join(s.configfsPath, bank),
+			devName:           devName,
+			levelPollInterval: o.levelPollInterval,
+		}
+		if c.cfg, err = k.readBank(c.configfsPath); err != nil {
+			return nil, err
+		}
+		if c.chipName, err = k.readAttr(c.configfsPath, "chip_name"); err != nil {
+			return nil, err
+		}
+		c.devPath = path.Join(k.devPath(), c.chipName)
+		c.sysfsPath = path.Join(k.sysfsPath(), "devices/platform", devName, c.chipName)
+		if err := k.waitDevNode(&c); err != nil {
+			return nil, err
+		}
+		c.lines = k.newLines(c.sysfsPath)
+		s.Chips = append(s.Chips, c)
+	}
+	return &s, nil
+}
+
+// readBank reconstructs the configuration of a bank from configfs.
+func (k *kernelBackend) readBank(bankPath string) (Bank, error) {
+	var b Bank
+	var err error
+	if b.Label, err = k.readAttr(bankPath, "label"); err != nil {
+		return b, err
+	}
+	numLines, err := k.readAttr(bankPath, "num_lines")
+	if err != nil {
+		return b, err
+	}
+	if b.NumLines, err = strconv.Atoi(numLines); err != nil {
+		return b, errors.Errorf("unexpected num_lines value: %s", numLines)
+	}
+	lines, err := k.dirs(bankPath, "line")
+	if err != nil {
+		return b, err
+	}
+	for _, l := range lines {
+		o, err := strconv.Atoi(strings.TrimPrefix(l, "line"))
+		if err != nil {
+			continue
+		}
+		linePath := path.Join(bankPath, l)
+		name, err := k.readAttr(linePath, "name")
+		if err != nil {
+			return b, err
+		}
+		if len(name) != 0 {
+			NamedLine{o, name}.applyBankOption(&b)
+		}
+		hogPath := path.Join(linePath, "hog")
+		if _, err := k.fs.stat(hogPath); err != nil {
+			continue
+		}
+		var h Hog
+		if h.Consumer, err = k.readAttr(hogPath, "name"); err != nil {
+			return b, err
+		}
+		dir, err := k.readAttr(hogPath, "direction")
+		if err != nil {
+			return b, err
+		}
+		if h.Direction, err = hogDirectionFromString(dir); err != nil {
+			return b, err
+		}
+		HoggedLine{o, h}.applyBankOption(&b)
+	}
+	return b, nil
+}
+
+// dirs returns the names of the subdirectories of p with the given prefix,
+// in natural order, i.e. "bank2" sorts before "bank10".
+func (k *kernelBackend) dirs(p, prefix string) ([]string, error) {
+	entries, err := k.fs.readDir(p)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), prefix) {
+			dirs = append(dirs, e.Name())
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return naturalLess(dirs[i], dirs[j])
+	})
+	return dirs, nil
+}
+
+// naturalLess compares names with a numeric suffix by prefix and then by the
+// value of the suffix.
+func naturalLess(a, b string) bool {
+	ap, an := splitNumericSuffix(a)
+	bp, bn := splitNumericSuffix(b)
+	if ap != bp || an < 0 || bn < 0 {
+		return a < b
+	}
+	return an < bn
+}
+
+func splitNumericSuffix(s string) (string, int) {
+	i := len(s)
+	for i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+		i--
+	}
+	n, err := strconv.Atoi(s[i:])
+	if err != nil {
+		return s, -1
+	}
+	return s[:i], n
+}
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openOptions returns the options required to open a sim using the stub.
+func (k *stubKernel) openOptions(options ...OpenSimOption) []OpenSimOption {
+	return append([]OpenSimOption{
+		WithConfigfsRoot(k.configfs),
+		WithSysfsRoot(k.sysfs),
+		WithDevRoot(k.dev),
+		withFS{k},
+	}, options...)
+}
+
+func TestOpenSim(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := NewSim(k.options(append(stubBanks(), WithName("basic"))...)...)
+	require.Nil(t, err)
+	defer s.Close()
+
+	as, err := OpenSim("basic", k.openOptions()...)
+	require.Nil(t, err)
+	require.NotNil(t, as)
+	assert.Equal(t, s.Name, as.Name)
+	require.Equal(t, len(s.Chips), len(as.Chips))
+	for i := range s.Chips {
+		c := &s.Chips[i]
+		oc := &as.Chips[i]
+		assert.Equal(t, c.Config(), oc.Config())
+		assert.Equal(t, c.ChipName(), oc.ChipName())
+		assert.Equal(t, c.DevPath(), oc.DevPath())
+		assert.Equal(t, c.devName, oc.devName)
+		assert.Equal(t, c.sysfsPath, oc.sysfsPath)
+		assert.Equal(t, c.configfsPath, oc.configfsPath)
+	}
+
+	// drive the sim via the opened sim
+	err = as.Chips[1].Pullup(5)
+	assert.Nil(t, err)
+	checkPull(t, &s.Chips[1], 5, LevelActive)
+
+	// detach
+	assert.Nil(t, as.Close())
+	assert.Nil(t, as.Chips)
+	checkPull(t, &s.Chips[1], 5, LevelActive)
+	assert.NotEmpty(t, k.residue())
+}
+
+func TestOpenSimTeardownOnClose(t *testing.T) {
+	k := newStubKernel(t)
+	_, err := NewSim(k.options(append(stubBanks(), WithName("basic"))...)...)
+	require.Nil(t, err)
+
+	s, err := OpenSim("basic", k.openOptions(WithTeardownOnClose())...)
+	require.Nil(t, err)
+	assert.Nil(t, s.Close())
+	assert.Empty(t, k.residue())
+}
+
+func TestOpenSimBankOrder(t *testing.T) {
+	k := newStubKernel(t)
+	simPath := path.Join(k.configfs, "gpio-sim", "script")
+	for _, b := range []string{"bank10", "bank2", "alpha"} {
+		require.Nil(t, k.mkdirAll(path.Join(simPath, b)))
+		require.Nil(t, k.writeFile(path.Join(simPath, b, "label"), []byte(b)))
+		require.Nil(t, k.writeFile(path.Join(simPath, b, "num_lines"), []byte("4")))
+	}
+	require.Nil(t, k.mkdirAll(path.Join(simPath, "bank2", "line3", "hog")))
+	require.Nil(t, k.writeFile(path.Join(simPath, "bank2", "line3", "hog", "name"), []byte("piggy")))
+	require.Nil(t, k.writeFile(path.Join(simPath, "bank2", "line3", "hog", "direction"), []byte("output-high")))
+	require.Nil(t, k.writeFile(path.Join(simPath, "live"), []byte("1")))
+
+	s, err := OpenSim("script", k.openOptions(WithTeardownOnClose())...)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(s.Chips))
+	assert.Equal(t, "alpha", s.Chips[0].Config().Label)
+	assert.Equal(t, "bank2", s.Chips[1].Config().Label)
+	assert.Equal(t, "bank10", s.Chips[2].Config().Label)
+	assert.Nil(t, s.Chips[1].Config().Names)
+	assert.Equal(t, map[int]Hog{3: {"piggy", HogDirectionOutputHigh}}, s.Chips[1].Config().Hogs)
+	assert.Nil(t, s.Close())
+	assert.Empty(t, k.residue())
+}
+
+func TestOpenSimErrors(t *testing.T) {
+	k := newStubKernel(t)
+	s, err := OpenSim("missing", k.openOptions()...)
+	assert.Nil(t, s)
+	assert.ErrorIs(t, err, ErrSimNotFound)
+	var ne SimNotFoundError
+	require.ErrorAs(t, err, &ne)
+	assert.Equal(t, "missing", ne.Name)
+
+	require.Nil(t, k.mkdirAll(path.Join(k.configfs, "gpio-sim", "dead", "bank0")))
+	s, err = OpenSim("dead", k.openOptions()...)
+	assert.Nil(t, s)
+	assert.NotNil(t, err)
+
+	require.Nil(t, os.Remove(path.Join(k.configfs, "gpio-sim", "dead", "bank0", "label")))
+	require.Nil(t, k.writeFile(path.Join(k.configfs, "gpio-sim", "dead", "live"), []byte("1")))
+	s, err = OpenSim("dead", k.openOptions()...)
+	assert.Nil(t, s)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func checkPull(t *testing.T, c *Chip, offset, xv int) {
+	v, err := c.Pull(offset)
+	assert.Nil(t, err)
+	assert.Equal(t, xv, v)
+}
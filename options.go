@@ -4,6 +4,11 @@
 
 package gpiosim
 
+import (
+	"fmt"
+	"time"
+)
+
 // NewSimOption defines the interface required to provide an option to NewSim.
 type NewSimOption interface {
 	applySimOption(*builder)
@@ -40,6 +45,14 @@ func (o HoggedLine) applyBankOption(b *Bank) {
 	if b.Hogs == nil {
 		b.Hogs = make(map[int]Hog)
 	}
+	if h, ok := b.Hogs[o.offset]; ok && h != o.Hog {
+		b.conflicts = append(b.conflicts, LineConfigError{
+			Offset: o.offset,
+			Reason: fmt.Sprintf("conflicting hogs '%s' (%s) and '%s' (%s)",
+				h.Consumer, hogDirectionToString(h.Direction),
+				o.Consumer, hogDirectionToString(o.Direction)),
+		})
+	}
 	b.Hogs[o.offset] = o.Hog
 }
 
@@ -70,5 +83,272 @@ func (o NamedLine) applyBankOption(b *Bank) {
 	if b.Names == nil {
 		b.Names = make(map[int]string)
 	}
+	if n, ok := b.Names[o.Offset]; ok && n != o.Name {
+		b.conflicts = append(b.conflicts, LineConfigError{
+			Offset: o.Offset,
+			Reason: fmt.Sprintf("conflicting names '%s' and '%s'", n, o.Name),
+		})
+	}
 	b.Names[o.Offset] = o.Name
 }
+
+// BackendOption defines the backend for a Sim.
+type BackendOption struct {
+	Backend
+}
+
+// WithBackend returns an option that defines the backend providing a Sim.
+//
+// If not provided then the sim is provided by the gpio-sim kernel module.
+func WithBackend(be Backend) BackendOption {
+	return BackendOption{be}
+}
+
+func (o BackendOption) applySimOption(b *builder) {
+	b.backend = o.Backend
+}
+
+// ConfigfsRootOption defines the mountpoint of configfs used by a Sim.
+type ConfigfsRootOption string
+
+// WithConfigfsRoot returns an option that defines the mountpoint of configfs
+// used to configure a Sim, e.g. "/sys/kernel/config".
+//
+// When provided, gpio-sim is expected to be found in the gpio-sim directory
+// of the root, and no attempt is made to load gpio-sim or mount configfs.
+//
+// This option only applies to the gpio-sim kernel backend, and is intended
+// for testing the library against a prepared directory tree.
+func WithConfigfsRoot(root string) ConfigfsRootOption {
+	return ConfigfsRootOption(root)
+}
+
+func (o ConfigfsRootOption) applySimOption(b *builder) {
+	b.kernel.configfsRoot = string(o)
+}
+
+func (o ConfigfsRootOption) applyListSimsOption(k *kernelBackend) {
+	k.configfsRoot = string(o)
+}
+
+func (o ConfigfsRootOption) applyOpenSimOption(op *opener) {
+	op.kernel.configfsRoot = string(o)
+}
+
+// SysfsRootOption defines the root of sysfs used by a Sim.
+type SysfsRootOption string
+
+// WithSysfsRoot returns an option that defines the root of sysfs used to
+// control the chips of a Sim.
+//
+// The default is "/sys".
+//
+// This option only applies to the gpio-sim kernel backend, and is intended
+// for testing the library against a prepared directory tree.
+func WithSysfsRoot(root string) SysfsRootOption {
+	return SysfsRootOption(root)
+}
+
+func (o SysfsRootOption) applySimOption(b *builder) {
+	b.kernel.sysfsRoot = string(o)
+}
+
+func (o SysfsRootOption) applyOpenSimOption(op *opener) {
+	op.kernel.sysfsRoot = string(o)
+}
+
+// DevRootOption defines the directory containing the gpiochip device nodes.
+type DevRootOption string
+
+// WithDevRoot returns an option that defines the directory containing the
+// gpiochip device nodes.
+//
+// The default is "/dev".
+//
+// This option only applies to the gpio-sim kernel backend, and is intended
+// for testing the library against a prepared directory tree.
+func WithDevRoot(root string) DevRootOption {
+	return DevRootOption(root)
+}
+
+func (o DevRootOption) applySimOption(b *builder) {
+	b.kernel.devRoot = string(o)
+}
+
+func (o DevRootOption) applyOpenSimOption(op *opener) {
+	op.kernel.devRoot = string(o)
+}
+
+func (o DevRootOption) applyListSimsOption(k *kernelBackend) {
+	k.devRoot = string(o)
+}
+
+// DevNodeTimeoutOption defines the period to wait for gpiochip device nodes.
+type DevNodeTimeoutOption time.Duration
+
+// WithDevNodeTimeout returns an option that defines the period NewSim waits
+// for the device node of each gpiochip to be created.
+//
+// Device nodes may be created asynchronously, e.g. by udev or mdev, after the
+// sim goes live.
+//
+// The default is 1 second.
+//
+// This option only applies to the gpio-sim kernel backend.
+func WithDevNodeTimeout(timeout time.Duration) DevNodeTimeoutOption {
+	return DevNodeTimeoutOption(timeout)
+}
+
+func (o DevNodeTimeoutOption) applySimOption(b *builder) {
+	b.kernel.devNodeTimeout = time.Duration(o)
+}
+
+// CachedLineFilesOption indicates that the sysfs files of the lines are kept
+// open between accesses.
+type CachedLineFilesOption struct{}
+
+// WithCachedLineFiles returns an option that keeps the sysfs files used to
+// access the lines open between accesses, rather than opening and closing
+// them on each access.
+//
+// This significantly increases the rate at which lines can be manipulated,
+// at the cost of up to two open files for each line accessed.
+// The files are closed when the sim is closed.
+//
+// This option only applies to the gpio-sim kernel backend.
+func WithCachedLineFiles() CachedLineFilesOption {
+	return CachedLineFilesOption{}
+}
+
+func (o CachedLineFilesOption) applySimOption(b *builder) {
+	b.kernel.cacheLineFiles = true
+}
+
+func (o CachedLineFilesOption) applyOpenSimOption(op *opener) {
+	op.kernel.cacheLineFiles = true
+}
+
+// LevelPollIntervalOption defines the period between checks of the levels of
+// watched lines.
+type LevelPollIntervalOption time.Duration
+
+// WithLevelPollInterval returns an option that defines the period between
+// checks of the levels of lines watched by Chip.WatchLevel, if the backend
+// does not notify of changes to the levels.
+//
+// The default is 10 milliseconds.
+func WithLevelPollInterval(interval time.Duration) LevelPollIntervalOption {
+	return LevelPollIntervalOption(interval)
+}
+
+func (o LevelPollIntervalOption) applySimOption(b *builder) {
+	b.levelPollInterval = time.Duration(o)
+}
+
+func (o LevelPollIntervalOption) applyOpenSimOption(op *opener) {
+	op.levelPollInterval = time.Duration(o)
+}
+
+// TeardownOnCloseOption indicates that closing an opened Sim removes it.
+type TeardownOnCloseOption struct{}
+
+// WithTeardownOnClose returns an option that causes Close to remove a sim
+// opened with OpenSim, rather than just detaching from it.
+func WithTeardownOnClose() TeardownOnCloseOption {
+	return TeardownOnCloseOption{}
+}
+
+func (o TeardownOnCloseOption) applyOpenSimOption(op *opener) {
+	op.kernel.detach = false
+}
+
+// SamplePeriodOption defines the period between samples of recorded lines.
+type SamplePeriodOption time.Duration
+
+// WithSamplePeriod returns an option that defines the period between samples
+// of the lines recorded by RecordVCD.
+//
+// The default is 1 millisecond.
+func WithSamplePeriod(period time.Duration) SamplePeriodOption {
+	return SamplePeriodOption(period)
+}
+
+func (o SamplePeriodOption) applyRecordOption(r *recorder) {
+	r.samplePeriod = time.Duration(o)
+}
+
+// TimeScaleOption defines the factor applied to the times of the events of
+// a trace.
+type TimeScaleOption float64
+
+// WithTimeScale returns an option that multiplies the times of the events of
+// a trace by the factor, so a factor greater than 1 plays the trace slower,
+// and less than 1 faster.
+//
+// The default is 1.
+func WithTimeScale(factor float64) TimeScaleOption {
+	return TimeScaleOption(factor)
+}
+
+func (o TimeScaleOption) applyTraceOption(tp *tracePlayer) {
+	tp.timeScale = float64(o)
+}
+
+// SignalLineOption maps a signal of a trace to a line.
+type SignalLineOption struct {
+	signal string
+	line   Line
+}
+
+// WithSignalLine returns an option that plays the signal of a trace on the
+// line, rather than the line with the same name as the signal.
+func WithSignalLine(signal string, line Line) SignalLineOption {
+	return SignalLineOption{signal, line}
+}
+
+func (o SignalLineOption) applyTraceOption(tp *tracePlayer) {
+	tp.lines[o.signal] = o.line
+}
+
+// UnmatchedSignalsIgnoredOption indicates that signals of a trace that do
+// not map to a line are ignored.
+type UnmatchedSignalsIgnoredOption struct{}
+
+// WithUnmatchedSignalsIgnored returns an option that ignores the signals of a
+// trace that do not map to a line, rather than failing to play the trace.
+func WithUnmatchedSignalsIgnored() UnmatchedSignalsIgnoredOption {
+	return UnmatchedSignalsIgnoredOption{}
+}
+
+func (o UnmatchedSignalsIgnoredOption) applyTraceOption(tp *tracePlayer) {
+	tp.ignoreUnmatched = true
+}
+
+// InvertedOption indicates that a wire inverts the level.
+type InvertedOption struct{}
+
+// WithInverted returns an option that inverts the level carried by a wire, so
+// the to line is pulled to the opposite of the level of the from line.
+func WithInverted() InvertedOption {
+	return InvertedOption{}
+}
+
+func (o InvertedOption) applyWireOption(w *Wire) {
+	w.inverted = true
+}
+
+// PropagationDelayOption defines the delay of a wire.
+type PropagationDelayOption time.Duration
+
+// WithPropagationDelay returns an option that delays the changes carried by a
+// wire, from when the change to the level of the from line is detected until
+// the pull of the to line is changed.
+//
+// The default is no delay.
+func WithPropagationDelay(delay time.Duration) PropagationDelayOption {
+	return PropagationDelayOption(delay)
+}
+
+func (o PropagationDelayOption) applyWireOption(w *Wire) {
+	w.delay = time.Duration(o)
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// SimInfo describes a sim found in configfs.
+type SimInfo struct {
+	// The name of the sim.
+	Name string
+
+	// The PID of the process that created the sim.
+	//
+	// This is only known for sims with a name generated by NewSim, and is
+	// zero otherwise.
+	PID int
+
+	// True if the sim is live.
+	Live bool
+
+	// True if the process that created the sim is no longer running.
+	//
+	// Sims with an unknown owner are never considered orphaned.
+	//
+	// The PID is only meaningful within the PID namespace of the creator, so
+	// sims created by processes in other PID namespaces, such as other
+	// containers sharing the host configfs, may be incorrectly considered
+	// orphaned.
+	Orphaned bool
+}
+
+// ListSimsOption defines the interface required to provide an option to
+// ListSims and CleanupOrphans.
+type ListSimsOption interface {
+	applyListSimsOption(*kernelBackend)
+}
+
+// ListSims returns the sims currently existing in configfs, including those
+// created by other processes.
+//
+// The available option is [WithConfigfsRoot].
+func ListSims(options ...ListSimsOption) ([]SimInfo, error) {
+	k := kernelBackend{fs: osFS{}}
+	for _, o := range options {
+		o.applyListSimsOption(&k)
+	}
+	return k.listSims()
+}
+
+// CleanupOrphans removes any sims created by processes that are no longer
+// running, such as tests that were killed before they could close their sims.
+//
+// Sims created by running processes, and sims with an unknown owner, such as
+// those created with WithName, are left untouched.
+// Live sims with lines requested by userspace are also left untouched, as
+// their creator may be running in another PID namespace, such as another
+// container sharing the host configfs, so only appear to be orphaned.
+//
+// Returns the names of the sims removed.  Any sims that could not be fully
+// removed, or could not be checked for requested lines, are reported in the
+// error, and are not included in the names.
+//
+// The available options are [WithConfigfsRoot] and [WithDevRoot].
+func CleanupOrphans(options ...ListSimsOption) ([]string, error) {
+	k := kernelBackend{fs: osFS{}}
+	for _, o := range options {
+		o.applyListSimsOption(&k)
+	}
+	sims, err := k.listSims()
+	if err != nil {
+		return nil, err
+	}
+	configfs, err := k.findConfigfsPath()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	var failed []string
+	for _, s := range sims {
+		if !s.Orphaned {
+			continue
+		}
+		simPath := path.Join(configfs, s.Name)
+		if s.Live {
+			inUse, err := k.inUse(simPath)
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %s", s.Name, err))
+				continue
+			}
+			if inUse {
+				continue
+			}
+		}
+		if err := k.removeConfigfs(simPath); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", s.Name, err))
+			continue
+		}
+		removed = append(removed, s.Name)
+	}
+	if len(failed) != 0 {
+		return removed, errors.Errorf("failed to remove orphans: %s", strings.Join(failed, "; "))
+	}
+	return removed, nil
+}
+
+func (k *kernelBackend) listSims() ([]SimInfo, error) {
+	configfs, err := k.findConfigfsPath()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := k.fs.readDir(configfs)
+	if err != nil {
+		return nil, err
+	}
+	var sims []SimInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		s := SimInfo{Name: e.Name()}
+		live, err := k.readAttr(path.Join(configfs, s.Name), "live")
+		s.Live = err == nil && live == "1"
+		if app, pid, ok := parseUniqueName(s.Name); ok {
+			s.PID = pid
+			s.Orphaned = !processRunning(pid, app)
+		}
+		sims = append(sims, s)
+	}
+	return sims, nil
+}
+
+// inUse returns true if any of the lines of a live sim are requested by
+// userspace.
+//
+// Hogged lines are ignored, as they are requested by gpio-sim itself.
+func (k *kernelBackend) inUse(simPath string) (bool, error) {
+	banks, err := k.fs.readDir(simPath)
+	if err != nil {
+		return false, err
+	}
+	for _, b := range banks {
+		// banks may have any name
+		if !b.IsDir() {
+			continue
+		}
+		bankPath := path.Join(simPath, b.Name())
+		chipName, err := k.readAttr(bankPath, "chip_name")
+		if err != nil {
+			return false, err
+		}
+		used, err := k.fs.usedLines(path.Join(k.devPath(), chipName))
+		if err != nil {
+			return false, err
+		}
+		for _, o := range used {
+			hogPath := path.Join(bankPath, fmt.Sprintf("line%d", o), "hog")
+			if _, err := k.fs.stat(hogPath); err != nil {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// removeConfigfs removes a sim from configfs, based on the directories found
+// in configfs rather than on the Bank configuration.
+func (k *kernelBackend) removeConfigfs(simPath string) error {
+	var terr TeardownError
+	terr.add(k.writeAttr(simPath, "live", "0"))
+	banks, err := k.fs.readDir(simPath)
+	terr.add(err)
+	for _, b := range banks {
+		// banks may have any name
+		if !b.IsDir() {
+			continue
+		}
+		bankPath := path.Join(simPath, b.Name())
+		lines, err := k.fs.readDir(bankPath)
+		terr.add(err)
+		for _, l := range lines {
+			if !l.IsDir() || !strings.HasPrefix(l.Name(), "line") {
+				continue
+			}
+			linePath := path.Join(bankPath, l.Name())
+			terr.add(k.fs.remove(path.Join(linePath, "hog")))
+			terr.add(k.fs.remove(linePath))
+		}
+		terr.add(k.fs.remove(bankPath))
+	}
+	terr.add(k.fs.remove(simPath))
+	if len(terr.Busy) != 0 || len(terr.Other) != 0 {
+		return terr
+	}
+	return nil
+}
+
+// uniqueNameRegexp matches the names generated by uniqueName.
+var uniqueNameRegexp = regexp.MustCompile(`^(.+)-p(\d+)-\d+$`)
+
+// parseUniqueName extracts the appname and PID from a name generated by
+// uniqueName.
+func parseUniqueName(name string) (string, int, bool) {
+	m := uniqueNameRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0, false
+	}
+	pid, err := strconv.Atoi(m[2])
+	if err != nil || pid <= 0 {
+		return "", 0, false
+	}
+	return m[1], pid, true
+}
+
+// processRunning returns true if the process with the given PID is running
+// the named app.
+//
+// If the executable of the process cannot be determined, e.g. due to lack of
+// permissions, then the process is assumed to be running the app.
+func processRunning(pid int, app string) bool {
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return true
+	}
+	// PID has been reused by another app
+	return path.Base(strings.TrimSuffix(exe, " (deleted)")) == app
+}
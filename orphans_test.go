@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUniqueName(t *testing.T) {
+	patterns := []struct {
+		name string
+		app  string
+		pid  int
+		ok   bool
+	}{
+		{"gpiosim.test-p1234-1", "gpiosim.test", 1234, true},
+		{"my-app-p42-17", "my-app", 42, true},
+		{"basic", "", 0, false},
+		{"app-p0-1", "", 0, false},
+		{"app-p12", "", 0, false},
+		{"-p12-1", "", 0, false},
+	}
+	for _, p := range patterns {
+		app, pid, ok := parseUniqueName(p.name)
+		assert.Equal(t, p.app, app, p.name)
+		assert.Equal(t, p.pid, pid, p.name)
+		assert.Equal(t, p.ok, ok, p.name)
+	}
+	app, pid, ok := parseUniqueName(uniqueName())
+	assert.True(t, ok)
+	assert.Equal(t, appName(), app)
+	assert.True(t, processRunning(pid, app))
+}
+
+// deadPID returns the PID of a process that is no longer running.
+func deadPID(t *testing.T) int {
+	cmd := exec.Command("true")
+	require.Nil(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+func TestOrphans(t *testing.T) {
+	k := newStubKernel(t)
+	dead := fmt.Sprintf("%s-p%d-1", appName(), deadPID(t))
+	reused := fmt.Sprintf("otherapp-p%d-1", deadPID(t))
+	names := []string{dead, reused, "basic"}
+	for _, name := range names {
+		_, err := NewSim(k.options(append(stubBanks(), WithName(name))...)...)
+		require.Nil(t, err)
+	}
+	s, err := NewSim(k.options(stubBanks()...)...)
+	require.Nil(t, err)
+	defer s.Close()
+	// an incomplete sim
+	require.Nil(t, k.mkdirAll(path.Join(k.configfs, "gpio-sim", dead+"0", "bank0", "line1", "hog")))
+
+	cleanup := []ListSimsOption{WithConfigfsRoot(k.configfs), WithDevRoot(k.dev), withFS{k}}
+	sims, err := ListSims(WithConfigfsRoot(k.configfs), withFS{k})
+	require.Nil(t, err)
+	require.Equal(t, 5, len(sims))
+	byName := map[string]SimInfo{}
+	for _, si := range sims {
+		byName[si.Name] = si
+	}
+	assert.True(t, byName[dead].Orphaned)
+	assert.True(t, byName[dead].Live)
+	assert.NotZero(t, byName[dead].PID)
+	assert.True(t, byName[dead+"0"].Orphaned)
+	assert.False(t, byName[dead+"0"].Live)
+	assert.True(t, byName[reused].Orphaned)
+	assert.Equal(t, SimInfo{Name: "basic", Live: true}, byName["basic"])
+	_, pid, _ := parseUniqueName(s.Name)
+	assert.Equal(t, SimInfo{Name: s.Name, PID: pid, Live: true}, byName[s.Name])
+
+	removed, err := CleanupOrphans(cleanup...)
+	assert.Nil(t, err)
+	sort.Strings(removed)
+	assert.Equal(t, []string{dead, dead + "0", reused}, removed)
+
+	sims, err = ListSims(WithConfigfsRoot(k.configfs), withFS{k})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(sims))
+	for _, si := range sims {
+		assert.False(t, si.Orphaned)
+	}
+
+	// failure to remove
+	_, err = NewSim(k.options(append(stubBanks(), WithName(dead))...)...)
+	require.Nil(t, err)
+	k.fail["remove config/gpio-sim/"+dead+"/bank1"] = fmt.Errorf("stuck")
+	removed, err = CleanupOrphans(cleanup...)
+	assert.NotNil(t, err)
+	assert.Empty(t, removed)
+	removed, err = CleanupOrphans(cleanup...)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{dead}, removed)
+
+	// lines in use
+	_, err = NewSim(k.options(append(stubBanks(), WithName(dead))...)...)
+	require.Nil(t, err)
+	data, err := os.ReadFile(path.Join(k.configfs, "gpio-sim", dead, "bank0", "chip_name"))
+	require.Nil(t, err)
+	chipName := strings.TrimSpace(string(data))
+	k.used[chipName] = []int{2, 5}
+	removed, err = CleanupOrphans(cleanup...)
+	assert.Nil(t, err)
+	assert.Empty(t, removed)
+	k.fail["used dev/"+chipName] = fmt.Errorf("no chip")
+	removed, err = CleanupOrphans(cleanup...)
+	assert.NotNil(t, err)
+	assert.Empty(t, removed)
+	// hogged lines are not in use
+	k.used[chipName] = []int{2}
+	removed, err = CleanupOrphans(cleanup...)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{dead}, removed)
+}
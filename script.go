@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Script contains the shell commands equivalent to the configfs operations
+// performed by NewSim and Sim.Close for the kernel backend.
+type Script struct {
+	// The name of the sim.
+	Name string
+
+	// The commands that create the sim and take it live.
+	Setup string
+
+	// The commands that take the sim offline and remove it.
+	Teardown string
+}
+
+// NewScript returns the shell commands that would be executed to create and
+// remove a sim with the provided options, without creating the sim.
+//
+// This allows a sim used in a test to be reproduced by hand.
+//
+// The commands are generated by the same code that manipulates configfs when
+// creating and removing a sim, so they cannot drift from the Go code.
+// They do not include loading gpio-sim, mounting configfs, or waiting for the
+// gpiochip device nodes.
+//
+// The options are the same as for NewSim, though only [WithName], [WithBank]
+// and [WithConfigfsRoot] affect the script.
+// If no WithConfigfsRoot is provided then configfs is assumed to be mounted
+// in the usual location, "/sys/kernel/config".
+func NewScript(options ...NewSimOption) (Script, error) {
+	b := builder{}
+	for _, o := range options {
+		o.applySimOption(&b)
+	}
+	return b.script()
+}
+
+// script generates the script equivalent to taking the sim live and then
+// closing it.
+func (b *builder) script() (Script, error) {
+	s, err := b.sim()
+	if err != nil {
+		return Script{}, err
+	}
+	configfsRoot := b.kernel.configfsRoot
+	if len(configfsRoot) == 0 {
+		configfsRoot = "/sys/kernel/config"
+	}
+	s.configfsPath = path.Join(configfsRoot, "gpio-sim", s.Name)
+	sf := &scriptFS{}
+	k := kernelBackend{fs: sf}
+	if err := k.setupConfigfs(s); err != nil {
+		return Script{}, err
+	}
+	if err := k.writeAttr(s.configfsPath, "live", "1"); err != nil {
+		return Script{}, err
+	}
+	setup := sf.String()
+	sf.Reset()
+	if err := k.cleanupConfigfs(s); err != nil {
+		return Script{}, err
+	}
+	return Script{Name: s.Name, Setup: setup, Teardown: sf.String()}, nil
+}
+
+// scriptFS records the sysFS operations that modify the file system as shell
+// commands.
+//
+// Operations that read the file system succeed, returning no data.
+type scriptFS struct {
+	strings.Builder
+}
+
+func (f *scriptFS) add(cmd ...string) {
+	f.WriteString(strings.Join(cmd, " "))
+	f.WriteByte('\n')
+}
+
+func (f *scriptFS) mkdir(p string) error {
+	f.add("mkdir", shellQuote(p))
+	return nil
+}
+
+func (f *scriptFS) mkdirAll(p string) error {
+	f.add("mkdir -p", shellQuote(p))
+	return nil
+}
+
+func (f *scriptFS) remove(p string) error {
+	f.add("rmdir", shellQuote(p))
+	return nil
+}
+
+func (f *scriptFS) readDir(p string) ([]fs.DirEntry, error) {
+	return nil, nil
+}
+
+func (f *scriptFS) readFile(p string) ([]byte, error) {
+	return nil, nil
+}
+
+func (f *scriptFS) writeFile(p string, data []byte) error {
+	f.add("echo", shellQuote(string(data)), ">", shellQuote(p))
+	return nil
+}
+
+func (f *scriptFS) stat(p string) (fs.FileInfo, error) {
+	return nil, nil
+}
+
+func (f *scriptFS) lstat(p string) (fs.FileInfo, error) {
+	return nil, nil
+}
+
+func (f *scriptFS) usedLines(p string) ([]int, error) {
+	return nil, nil
+}
+
+// shellQuote quotes the string for use as a single shell word.
+//
+// Strings that need no quoting are returned unchanged.
+func shellQuote(s string) string {
+	safe := len(s) != 0
+	for _, r := range s {
+		if !strings.ContainsRune("/-_.:,+=@%", r) &&
+			(r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warthog618/go-gpiosim"
+)
+
+func TestNewScript(t *testing.T) {
+	s, err := gpiosim.NewScript(
+		gpiosim.WithName("scripted"),
+		gpiosim.WithConfigfsRoot("/config"),
+		gpiosim.WithBank(gpiosim.NewBank("it's", 8,
+			gpiosim.WithNamedLine(5, "LED 0"),
+			gpiosim.WithNamedLine(3, "LED1"),
+			gpiosim.WithHoggedLine(3, "piggy", gpiosim.HogDirectionOutputHigh),
+		)),
+		gpiosim.WithBank(gpiosim.NewBank("right", 4)),
+	)
+	require.Nil(t, err)
+	assert.Equal(t, "scripted", s.Name)
+	assert.Equal(t, `mkdir -p /config/gpio-sim/scripted/bank0
+echo 'it'\''s' > /config/gpio-sim/scripted/bank0/label
+echo 8 > /config/gpio-sim/scripted/bank0/num_lines
+mkdir /config/gpio-sim/scripted/bank0/line3
+echo LED1 > /config/gpio-sim/scripted/bank0/line3/name
+mkdir /config/gpio-sim/scripted/bank0/line5
+echo 'LED 0' > /config/gpio-sim/scripted/bank0/line5/name
+mkdir -p /config/gpio-sim/scripted/bank0/line3/hog
+echo piggy > /config/gpio-sim/scripted/bank0/line3/hog/name
+echo output-high > /config/gpio-sim/scripted/bank0/line3/hog/direction
+mkdir -p /config/gpio-sim/scripted/bank1
+echo right > /config/gpio-sim/scripted/bank1/label
+echo 4 > /config/gpio-sim/scripted/bank1/num_lines
+echo 1 > /config/gpio-sim/scripted/live
+`, s.Setup)
+	assert.Equal(t, `echo 0 > /config/gpio-sim/scripted/live
+rmdir /config/gpio-sim/scripted/bank0/line3/hog
+rmdir /config/gpio-sim/scripted/bank0/line3
+rmdir /config/gpio-sim/scripted/bank0/line5
+rmdir /config/gpio-sim/scripted/bank0
+rmdir /config/gpio-sim/scripted/bank1
+rmdir /config/gpio-sim/scripted
+`, s.Teardown)
+
+	// generated name
+	s, err = gpiosim.NewScript(gpiosim.WithBank(gpiosim.NewBank("left", 8)))
+	require.Nil(t, err)
+	assert.NotEmpty(t, s.Name)
+	assert.Contains(t, s.Setup, "/sys/kernel/config/gpio-sim/"+s.Name+"/bank0\n")
+
+	// invalid config
+	_, err = gpiosim.NewScript()
+	assert.ErrorIs(t, err, gpiosim.ErrNoBanks)
+	_, err = gpiosim.NewScript(gpiosim.WithBank(gpiosim.NewBank("left", 0)))
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidBank)
+}
+
+// TestBasicSimScript checks that tools/basic_sim.sh matches the sim described
+// in its comment.
+func TestBasicSimScript(t *testing.T) {
+	s, err := gpiosim.NewScript(
+		gpiosim.WithName("basic"),
+		gpiosim.WithBank(gpiosim.NewBank("fish", 8,
+			gpiosim.WithNamedLine(3, "banana"),
+			gpiosim.WithNamedLine(5, "apple"),
+			gpiosim.WithHoggedLine(2, "breath", gpiosim.HogDirectionOutputLow),
+		)),
+		gpiosim.WithBank(gpiosim.NewBank("babel", 42,
+			gpiosim.WithNamedLine(3, "piñata"),
+			gpiosim.WithNamedLine(5, "piggly"),
+			gpiosim.WithNamedLine(7, "apple"),
+			gpiosim.WithHoggedLine(2, "hogster", gpiosim.HogDirectionOutputHigh),
+			gpiosim.WithHoggedLine(8, "breath", gpiosim.HogDirectionInput),
+		)),
+	)
+	require.Nil(t, err)
+	data, err := os.ReadFile("tools/basic_sim.sh")
+	require.Nil(t, err)
+	var setup, teardown strings.Builder
+	for _, l := range strings.Split(string(data), "\n") {
+		switch {
+		case len(l) == 0:
+		case strings.HasPrefix(l, "#  "):
+			teardown.WriteString(strings.TrimPrefix(l, "#  ") + "\n")
+		case !strings.HasPrefix(l, "#"):
+			setup.WriteString(l + "\n")
+		}
+	}
+	assert.Equal(t, s.Setup, setup.String())
+	assert.Equal(t, s.Teardown, teardown.String())
+}
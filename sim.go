@@ -5,14 +5,13 @@
 package gpiosim
 
 import (
-	"bufio"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
-	"os/exec"
 	"path"
-	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -33,11 +32,65 @@ type Sim struct {
 
 	// Path to the gpio-sim in configfs.
 	configfsPath string
+
+	// The backend providing the simulator.
+	backend Backend
+
+	// The wires, buses and devices connected to lines of the sim.
+	connections *connectionSet
+}
+
+// connectionSet contains the connections to the lines of a sim that are to be
+// closed when the sim is closed.
+type connectionSet struct {
+	mu      sync.Mutex
+	closers []io.Closer
+}
+
+// add adds the connection to the set.
+func (cs *connectionSet) add(c io.Closer) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.closers = append(cs.closers, c)
+}
+
+// remove removes the connection from the set, if present.
+func (cs *connectionSet) remove(c io.Closer) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for i, cc := range cs.closers {
+		if cc == c {
+			cs.closers = append(cs.closers[:i], cs.closers[i+1:]...)
+			return
+		}
+	}
+}
+
+// take removes all the connections from the set, returning them.
+func (cs *connectionSet) take() []io.Closer {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	closers := cs.closers
+	cs.closers = nil
+	return closers
+}
+
+// len returns the number of connections in the set.
+func (cs *connectionSet) len() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return len(cs.closers)
 }
 
 // NewSim contstructs a Sim based on the provided options.
 //
-// The available options are [WithName] and [WithBank].
+// The available options are [WithName], [WithBank], [WithBackend],
+// [WithConfigfsRoot], [WithSysfsRoot], [WithDevRoot], [WithDevNodeTimeout],
+// [WithCachedLineFiles] and [WithLevelPollInterval].
 //
 // Providing a WithName is optional, and is only necessary in rare cases.
 // If you don't know if you need to provide a name then you don't.
@@ -46,6 +99,9 @@ type Sim struct {
 // If no name is provided then a unique name is automatically generated.
 //
 // At least one WithBank option must be provided.
+//
+// If no WithBackend option is provided then the sim is provided by the
+// gpio-sim kernel module.
 func NewSim(options ...NewSimOption) (*Sim, error) {
 	b := builder{}
 	for _, o := range options {
@@ -56,77 +112,67 @@ func NewSim(options ...NewSimOption) (*Sim, error) {
 
 // Close deconstructs the sim, removing all gpio-sim configuration and the
 // corresponding gpiochips.
-func (s *Sim) Close() {
-	s.cleanupConfigfs()
-	s.Chips = nil
-}
-
-// cleanupConfigfs removes all the gpio-sim configurtation for the sim.
-func (s *Sim) cleanupConfigfs() error {
-	// not strictly necessary to set live=0, but it can't hurt.
-	err := writeAttr(s.configfsPath, "live", "0")
-	if err != nil {
-		return err
+//
+// Any wires or buses connecting the lines of the sim are disconnected first.
+//
+// If the sim cannot be fully removed then a TeardownError is returned,
+// detailing each part that could not be removed, and the sim remains open so
+// Close may be retried.
+// Closing a sim that is already closed has no effect.
+func (s *Sim) Close() error {
+	if s.backend == nil {
+		return nil
 	}
-	for i, c := range s.Chips {
-		bankPath := path.Join(s.configfsPath, fmt.Sprintf("bank%d", i))
-		if _, err := os.Stat(bankPath); err != nil {
-			continue
-		}
-		for o := range c.cfg.Hogs {
-			linePath := path.Join(bankPath, fmt.Sprintf("line%d", o))
-			os.Remove(path.Join(linePath, "hog"))
-			os.Remove(linePath)
-		}
-		for o := range c.cfg.Names {
-			linePath := path.Join(bankPath, fmt.Sprintf("line%d", o))
-			os.Remove(linePath)
-		}
-		os.Remove(bankPath)
+	// the connections remove themselves from the set when closed, so they are
+	// taken from the set before being closed
+	for _, c := range s.connections.take() {
+		c.Close()
+	}
+	if err := s.backend.close(s); err != nil {
+		return err
 	}
-	os.Remove(s.configfsPath)
+	s.backend = nil
+	s.Chips = nil
 	return nil
 }
 
-// setupConfigfs constructs the gpio-sim configuration in configfs for the sim,
-// including each of the simulated chips.
-func (s *Sim) setupConfigfs() error {
-	for i, c := range s.Chips {
-		bankPath := path.Join(s.configfsPath, fmt.Sprintf("bank%d", i))
-		if err := os.MkdirAll(bankPath, 0755); err != nil {
-			return err
-		}
-		if err := writeAttr(bankPath, "label", c.cfg.Label); err != nil {
-			return err
-		}
-		if err := writeAttr(bankPath, "num_lines", fmt.Sprintf("%d", c.cfg.NumLines)); err != nil {
+// CloseWithTimeout deconstructs the sim, as per Close, retrying while the sim
+// is busy until the sim is closed or the timeout expires.
+//
+// Failures other than the sim being busy are not retried.
+func (s *Sim) CloseWithTimeout(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := s.Close()
+		if err == nil || !errors.Is(err, ErrBusy) || time.Now().After(deadline) {
 			return err
 		}
-		for o, n := range c.cfg.Names {
-			linePath := path.Join(bankPath, fmt.Sprintf("line%d", o))
-			if err := os.Mkdir(linePath, 0755); err != nil {
-				return err
-			}
-			if err := writeAttr(linePath, "name", n); err != nil {
-				return err
-			}
-		}
-		for o, h := range c.cfg.Hogs {
-			hogPath := path.Join(bankPath, fmt.Sprintf("line%d", o), "hog")
-			if err := os.MkdirAll(hogPath, 0755); err != nil {
-				return err
-			}
-			if err := writeAttr(hogPath, "name", h.Consumer); err != nil {
-				return err
-			}
-			if err := writeAttr(hogPath, "direction", hogDirectionToString(h.Direction)); err != nil {
-				return err
-			}
-		}
+		time.Sleep(closeRetryInterval)
 	}
-	return nil
 }
 
+// FindLine returns the line with the given name.
+//
+// Line names need not be unique, so returns an AmbiguousLineError if more
+// than one line in the sim has the name, or a LineNotFoundError if none do.
+// Use FindLines to find all the lines with the name.
+func (s *Sim) FindLine(name string) (Line, error) {
+	return uniqueLine(name, s.FindLines(name))
+}
+
+// FindLines returns all the lines with the given name, in order of chip and
+// then offset.
+func (s *Sim) FindLines(name string) []Line {
+	var lines []Line
+	for i := range s.Chips {
+		lines = append(lines, s.Chips[i].FindLines(name)...)
+	}
+	return lines
+}
+
+// The period between attempts to close a busy sim.
+const closeRetryInterval = 10 * time.Millisecond
+
 // builder contains all the information required to build a sim.
 type builder struct {
 	// The name for the simulator in the configfs space.
@@ -140,113 +186,69 @@ type builder struct {
 	//
 	// Each bank becomes a chip when the simulator goes live.
 	banks []Bank
+
+	// The backend that provides the simulator.
+	//
+	// If nil then the kernel backend is used.
+	backend Backend
+
+	// The configuration for the kernel backend.
+	kernel kernelBackend
+
+	// The period between checks of the levels of watched lines.
+	levelPollInterval time.Duration
 }
 
-// live build creates the gpio-sim configuration for the sim and takes it live.
+// live build creates the configuration for the sim and takes it live.
 func (b *builder) live() (*Sim, error) {
-	if len(b.banks) == 0 {
-		return nil, errors.New("no banks defined")
-	}
-	if len(b.name) == 0 {
-		b.name = uniqueName()
-	}
-	configfsPath, err := findConfigfsPath()
+	s, err := b.sim()
 	if err != nil {
 		return nil, err
 	}
-	configfsPath = path.Join(configfsPath, b.name)
-	if _, err := os.Stat(configfsPath); err == nil {
-		return nil, errors.Errorf("sim with name '%s' already exists", b.name)
-	}
-
-	s := Sim{Name: b.name, configfsPath: configfsPath}
-	for _, k := range b.banks {
-		s.Chips = append(s.Chips, Chip{cfg: k})
-	}
-	err = s.setupConfigfs()
-	if err == nil {
-		err = writeAttr(s.configfsPath, "live", "1")
+	if b.backend == nil {
+		k := b.kernel
+		b.backend = &k
 	}
-	if err != nil {
-		s.Close()
+	s.backend = b.backend
+	if err := b.backend.live(s); err != nil {
 		return nil, err
 	}
-	devName, err := readAttr(s.configfsPath, "dev_name")
-	if err != nil {
-		s.Close()
-		return nil, err
-	}
-	for i := range s.Chips {
-		bankPath := path.Join(s.configfsPath, fmt.Sprintf("bank%d", i))
-		chipName, err := readAttr(bankPath, "chip_name")
-		if err != nil {
-			s.Close()
-			return nil, err
-		}
-		s.Chips[i].devName = devName
-		s.Chips[i].chipName = chipName
-		devPath := path.Join("/dev", chipName)
-		stat, err := os.Lstat(devPath)
-		if err != nil {
-			return nil, err
-		}
-		if stat.Mode()&fs.ModeSymlink != 0 {
-			err = errors.New("A symlink (" + devPath + ") is masking GPIO device " + chipName)
-			return nil, err
-		}
-		s.Chips[i].devPath = devPath
-		s.Chips[i].sysfsPath = path.Join("/sys/devices/platform", devName, chipName)
-	}
-	return &s, nil
+	return s, nil
 }
 
-// configfsMountPoint finds the location where configfs is mounted in the file system.
-//
-// If no mountpoint is found, attempts to mount it in the usual "/sys/kernel/config".
-func configfsMountPoint() (string, error) {
-	file, err := os.Open("/proc/mounts")
-	if err != nil {
-		return "", err
+// sim checks the configuration and constructs the sim, but does not take it
+// live.
+func (b *builder) sim() (*Sim, error) {
+	if len(b.banks) == 0 {
+		return nil, ErrNoBanks
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		words := strings.Fields(scanner.Text())
-		if len(words) >= 6 && words[2] == "configfs" {
-			return words[1], nil
-		}
+	if err := b.validate(); err != nil {
+		return nil, err
 	}
-	// not mounted, so try to mount
-	configfs := "/sys/kernel/config"
-	cmd := exec.Command("mount", "-t", "configfs", "configfs", configfs)
-	if err = cmd.Run(); err == nil {
-		return configfs, nil
+	if len(b.name) == 0 {
+		b.name = uniqueName()
+	}
+	s := Sim{Name: b.name, connections: &connectionSet{}}
+	for _, k := range b.banks {
+		s.Chips = append(s.Chips, Chip{cfg: k, levelPollInterval: b.levelPollInterval})
 	}
-	return "", errors.New("can't find configfs mountpoint")
+	return &s, nil
 }
 
-// findConfigfsPath finds the location of gpio-sim in theconfigfs.
-func findConfigfsPath() (string, error) {
-	configfs := "/sys/kernel/config/gpio-sim"
-	if _, err := os.Stat(configfs); err == nil {
-		return configfs, nil
-	}
-	// try loading gpio-sim module
-	cmd := exec.Command("modprobe", "gpio-sim")
-	if err := cmd.Run(); err == nil {
-		if _, err := os.Stat(configfs); err == nil {
-			return configfs, nil
+// validate checks all the banks, returning a ConfigError containing any
+// problems found.
+func (b *builder) validate() error {
+	cerr := ConfigError{Banks: make(map[int]BankError)}
+	for i := range b.banks {
+		var berr BankError
+		if errors.As(b.banks[i].Validate(), &berr) {
+			cerr.Banks[i] = berr
 		}
 	}
-	// check mountpoints in case configfs is mounted somewhere unusual
-	if configfs, err := configfsMountPoint(); err == nil {
-		configfs = path.Join(configfs, "gpio-sim")
-		if _, err := os.Stat(configfs); err == nil {
-			return configfs, nil
-		}
+	if len(cerr.Banks) != 0 {
+		return cerr
 	}
-	return "", errors.New("gpio-sim module not loaded")
+	return nil
 }
 
 var simCounter uint32 = 0
@@ -270,16 +272,3 @@ func appName() string {
 	}
 	return path.Base(str)
 }
-
-// hogDirectionToString maps the HogDirection to the corresponding string
-// used when configuring the gpio-sim.
-func hogDirectionToString(d HogDirection) string {
-	switch d {
-	case HogDirectionOutputLow:
-		return "output-low"
-	case HogDirectionOutputHigh:
-		return "output-high"
-	default:
-		return "input"
-	}
-}
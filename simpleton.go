@@ -8,8 +8,13 @@ type Simpleton struct {
 	Sim
 }
 
-func NewSimpleton(numLines int) (*Simpleton, error) {
-	s, err := NewSim(WithBank(NewBank("simpleton", numLines)))
+// NewSimpleton constructs a Simpleton with numLines lines.
+//
+// The options are passed through to NewSim, and are typically limited to
+// [WithName] and [WithBackend].
+func NewSimpleton(numLines int, options ...NewSimOption) (*Simpleton, error) {
+	options = append([]NewSimOption{WithBank(NewBank("simpleton", numLines))}, options...)
+	s, err := NewSim(options...)
 	if s == nil {
 		return nil, err
 	}
@@ -35,6 +40,14 @@ func (s *Simpleton) DevPath() string {
 	return s.Chips[0].devPath
 }
 
+// Line returns the line at the given offset.
+//
+// Returns an InvalidOffsetError if the offset is outside the range of the
+// chip.
+func (s *Simpleton) Line(offset int) (Line, error) {
+	return s.Chips[0].Line(offset)
+}
+
 // Level returns the level the line is being pulled to.
 //
 // If the line is requested as an output then this is the level userspace is
@@ -64,6 +77,25 @@ func (s *Simpleton) SetPull(offset int, level int) error {
 	return s.Chips[0].SetPull(offset, level)
 }
 
+// SetPulls sets the pulls of several lines, with the map being from offset
+// to pull.
+func (s *Simpleton) SetPulls(pulls map[int]int) error {
+	return s.Chips[0].SetPulls(pulls)
+}
+
+// SetPullsMask sets the pulls of the lines selected by the mask to the value
+// of the corresponding bit in bits.
+func (s *Simpleton) SetPullsMask(mask, bits uint64) error {
+	return s.Chips[0].SetPullsMask(mask, bits)
+}
+
+// Levels returns the levels of several lines, in the order of the offsets.
+//
+// If no offsets are provided then the levels of all the lines are returned.
+func (s *Simpleton) Levels(offsets ...int) ([]int, error) {
+	return s.Chips[0].Levels(offsets...)
+}
+
 // Toggle flips the pull of the given line.
 //
 // If it was pull-up it becomes pull-down, and vice versa.
@@ -21,35 +21,50 @@
 # 		gpiosim.WithHoggedLine(8, "breath", gpiosim.HogDirectionInput),
 # 	)),
 # )
+#
+# The commands are generated by gpiosim.NewScript, using the same options.
 
-mkdir /sys/kernel/config/gpio-sim/basic
-
-mkdir /sys/kernel/config/gpio-sim/basic/bank0
-echo "fish" > /sys/kernel/config/gpio-sim/basic/bank0/label
+mkdir -p /sys/kernel/config/gpio-sim/basic/bank0
+echo fish > /sys/kernel/config/gpio-sim/basic/bank0/label
 echo 8 > /sys/kernel/config/gpio-sim/basic/bank0/num_lines
 mkdir /sys/kernel/config/gpio-sim/basic/bank0/line3
-echo "banana" > /sys/kernel/config/gpio-sim/basic/bank0/line3/name
+echo banana > /sys/kernel/config/gpio-sim/basic/bank0/line3/name
 mkdir /sys/kernel/config/gpio-sim/basic/bank0/line5
-echo "apple" > /sys/kernel/config/gpio-sim/basic/bank0/line5/name
-mkdir -p /sys/kernel/config/gpio-sim/basic/bank0/line1/hog
-echo "breath" > /sys/kernel/config/gpio-sim/basic/bank0/line1/hog/name
-echo "output-high" > /sys/kernel/config/gpio-sim/basic/bank0/line1/hog/direction
-
-mkdir /sys/kernel/config/gpio-sim/basic/bank1
-echo "babel" > /sys/kernel/config/gpio-sim/basic/bank1/label
-echo 12 > /sys/kernel/config/gpio-sim/basic/bank1/num_lines
+echo apple > /sys/kernel/config/gpio-sim/basic/bank0/line5/name
+mkdir -p /sys/kernel/config/gpio-sim/basic/bank0/line2/hog
+echo breath > /sys/kernel/config/gpio-sim/basic/bank0/line2/hog/name
+echo output-low > /sys/kernel/config/gpio-sim/basic/bank0/line2/hog/direction
+mkdir -p /sys/kernel/config/gpio-sim/basic/bank1
+echo babel > /sys/kernel/config/gpio-sim/basic/bank1/label
+echo 42 > /sys/kernel/config/gpio-sim/basic/bank1/num_lines
 mkdir /sys/kernel/config/gpio-sim/basic/bank1/line3
-echo "piñata" > /sys/kernel/config/gpio-sim/basic/bank1/line3/name
+echo 'piñata' > /sys/kernel/config/gpio-sim/basic/bank1/line3/name
 mkdir /sys/kernel/config/gpio-sim/basic/bank1/line5
-echo "piggly" > /sys/kernel/config/gpio-sim/basic/bank1/line5/name
+echo piggly > /sys/kernel/config/gpio-sim/basic/bank1/line5/name
 mkdir /sys/kernel/config/gpio-sim/basic/bank1/line7
-echo "apple" > /sys/kernel/config/gpio-sim/basic/bank1/line7/name
+echo apple > /sys/kernel/config/gpio-sim/basic/bank1/line7/name
 mkdir -p /sys/kernel/config/gpio-sim/basic/bank1/line2/hog
-echo "hogster" > /sys/kernel/config/gpio-sim/basic/bank1/line2/hog/name
-echo "input" > /sys/kernel/config/gpio-sim/basic/bank1/line2/hog/direction
+echo hogster > /sys/kernel/config/gpio-sim/basic/bank1/line2/hog/name
+echo output-high > /sys/kernel/config/gpio-sim/basic/bank1/line2/hog/direction
 mkdir -p /sys/kernel/config/gpio-sim/basic/bank1/line8/hog
-echo "breath" > /sys/kernel/config/gpio-sim/basic/bank1/line8/hog/name
-echo "output-low" > /sys/kernel/config/gpio-sim/basic/bank1/line8/hog/direction
-
+echo breath > /sys/kernel/config/gpio-sim/basic/bank1/line8/hog/name
+echo input > /sys/kernel/config/gpio-sim/basic/bank1/line8/hog/direction
 echo 1 > /sys/kernel/config/gpio-sim/basic/live
 
+# The sim can be removed using:
+#
+#  echo 0 > /sys/kernel/config/gpio-sim/basic/live
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank0/line2/hog
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank0/line2
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank0/line3
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank0/line5
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank0
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank1/line2/hog
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank1/line2
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank1/line8/hog
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank1/line8
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank1/line3
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank1/line5
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank1/line7
+#  rmdir /sys/kernel/config/gpio-sim/basic/bank1
+#  rmdir /sys/kernel/config/gpio-sim/basic
@@ -7,6 +7,10 @@
 # This should only be necessary if a test was killed abnormally
 # preventing it from cleaning up the sims it created, or if you
 # created a sim using basic_sim.sh.
+#
+# Note that this removes ALL sims, including those owned by other running
+# processes.  To only remove sims created by processes that are no longer
+# running, use gpiosim.CleanupOrphans instead.
 
 find /sys/kernel/config/gpio-sim -type d -name hog -print0 2>/dev/null | xargs -0 -r rmdir
 find /sys/kernel/config/gpio-sim -type d -name "line*" -print0  2>/dev/null | xargs -0 -r rmdir
@@ -0,0 +1,564 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TraceEvent is a change to the level of a signal in a trace.
+type TraceEvent struct {
+	// The time of the change, relative to the start of the trace.
+	Time time.Duration
+
+	// The name of the signal.
+	Signal string
+
+	// The new level of the signal.
+	Level int
+}
+
+// Trace is a recording of the levels of a set of signals, such as one
+// captured from hardware by a logic analyzer.
+type Trace struct {
+	// The changes to the signals, in time order.
+	Events []TraceEvent
+}
+
+// Signals returns the names of the signals in the trace, in order of their
+// first event.
+func (t Trace) Signals() []string {
+	var signals []string
+	seen := make(map[string]bool)
+	for _, e := range t.Events {
+		if !seen[e.Signal] {
+			seen[e.Signal] = true
+			signals = append(signals, e.Signal)
+		}
+	}
+	return signals
+}
+
+// LoadTrace reads a trace from a file.
+//
+// Files with a .csv extension are parsed by ParseCSV, and all others by
+// ParseVCD.
+func LoadTrace(filename string) (Trace, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Trace{}, err
+	}
+	defer f.Close()
+	var t Trace
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		t, err = ParseCSV(f)
+	} else {
+		t, err = ParseVCD(f)
+	}
+	if te, ok := err.(TraceError); ok {
+		te.File = filename
+		err = te
+	}
+	return t, err
+}
+
+// ParseCSV parses a trace in CSV format.
+//
+// Each record is of the form timestamp,signal,level, where the timestamp is
+// in seconds and the level is 0 or 1.  A header row may precede the records.
+func ParseCSV(r io.Reader) (Trace, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	var t Trace
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if pe, ok := err.(*csv.ParseError); ok {
+				return Trace{}, TraceError{Line: pe.Line, Reason: pe.Err.Error()}
+			}
+			return Trace{}, err
+		}
+		line, _ := cr.FieldPos(0)
+		secs, err := strconv.ParseFloat(record[0], 64)
+		if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+			if first {
+				// header
+				continue
+			}
+			return Trace{}, TraceError{Line: line, Reason: "invalid timestamp: " + record[0]}
+		}
+		level, err := parseTraceLevel(record[2])
+		if err != nil {
+			return Trace{}, TraceError{Line: line, Reason: "invalid level: " + record[2]}
+		}
+		t.Events = append(t.Events, TraceEvent{
+			Time:   time.Duration(math.Round(secs * float64(time.Second))),
+			Signal: record[1],
+			Level:  level,
+		})
+	}
+	sort.SliceStable(t.Events, func(i, j int) bool {
+		return t.Events[i].Time < t.Events[j].Time
+	})
+	return t, nil
+}
+
+func parseTraceLevel(s string) (int, error) {
+	switch s {
+	case "0":
+		return LevelInactive, nil
+	case "1":
+		return LevelActive, nil
+	}
+	return 0, strconv.ErrSyntax
+}
+
+// ParseVCD parses a trace in IEEE 1364 Value Change Dump format.
+//
+// Only single bit signals are supported - the changes to wider signals are
+// ignored, as are changes to the unknown and high impedance states.
+// Signals are named by their reference, excluding the scope, unless signals
+// in different scopes have the same reference, in which case they are
+// qualified by their scope, e.g. "fish.apple".
+//
+// Recordings made by RecordVCD include a "<name>_pull" signal for the pull of
+// each line, alongside the level signal, which PlayTrace plays in place of
+// the level.
+func ParseVCD(r io.Reader) (Trace, error) {
+	p := vcdParser{
+		s:    bufio.NewScanner(r),
+		tick: 1e6,
+	}
+	p.s.Split(p.splitWords)
+	return p.parse()
+}
+
+// vcdParser parses a VCD file word by word, tracking the line number for
+// errors.
+type vcdParser struct {
+	s *bufio.Scanner
+
+	// The line number of the current word.
+	line int
+
+	// The number of newlines in the words returned so far.
+	newlines int
+
+	// The single bit signals declared so far.
+	vars []vcdVar
+
+	// The names of the current scope and its parents, outermost first.
+	scopes []string
+
+	// The names of single bit signals, keyed by identifier code, or nil if
+	// not yet determined from the vars.
+	signals map[string]string
+
+	// The period of a tick of the timestamps, from the timescale, in
+	// femtoseconds.
+	tick float64
+}
+
+// vcdVar is a single bit signal declared in a VCD file.
+type vcdVar struct {
+	// The identifier code used in value changes.
+	id string
+
+	// The scope containing the signal, with nested scopes separated by '.'.
+	scope string
+
+	// The reference, or name, of the signal within the scope.
+	ref string
+}
+
+// names determines the names of the signals, qualifying references that are
+// declared in more than one scope with their scope.
+func (p *vcdParser) names() map[string]string {
+	if p.signals != nil {
+		return p.signals
+	}
+	scopes := make(map[string]map[string]bool)
+	for _, v := range p.vars {
+		if scopes[v.ref] == nil {
+			scopes[v.ref] = make(map[string]bool)
+		}
+		scopes[v.ref][v.scope] = true
+	}
+	p.signals = make(map[string]string)
+	for _, v := range p.vars {
+		if _, ok := p.signals[v.id]; ok {
+			// an alias of an earlier signal
+			continue
+		}
+		name := v.ref
+		if len(scopes[v.ref]) > 1 && len(v.scope) != 0 {
+			name = v.scope + "." + v.ref
+		}
+		p.signals[v.id] = name
+	}
+	return p.signals
+}
+
+// splitWords is a bufio.SplitFunc that returns whitespace separated words,
+// counting the newlines skipped.
+func (p *vcdParser) splitWords(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for ; start < len(data) && isVCDSpace(data[start]); start++ {
+		if data[start] == '\n' {
+			p.newlines++
+		}
+	}
+	for i := start; i < len(data); i++ {
+		if isVCDSpace(data[i]) {
+			return i, data[start:i], nil
+		}
+	}
+	if atEOF && len(data) > start {
+		return len(data), data[start:], nil
+	}
+	return start, nil, nil
+}
+
+func isVCDSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func (p *vcdParser) next() (string, bool) {
+	if !p.s.Scan() {
+		return "", false
+	}
+	p.line = p.newlines + 1
+	return p.s.Text(), true
+}
+
+// section returns the words up to the $end of the current section.
+func (p *vcdParser) section(keyword string) ([]string, error) {
+	var words []string
+	for {
+		w, ok := p.next()
+		if !ok {
+			return nil, p.errorf("missing $end for %s", keyword)
+		}
+		if w == "$end" {
+			return words, nil
+		}
+		words = append(words, w)
+	}
+}
+
+func (p *vcdParser) errorf(reason string, args ...interface{}) error {
+	if err := p.s.Err(); err != nil {
+		return err
+	}
+	return TraceError{Line: p.line, Reason: fmt.Sprintf(reason, args...)}
+}
+
+func (p *vcdParser) parse() (Trace, error) {
+	var t Trace
+	now := time.Duration(0)
+	for {
+		w, ok := p.next()
+		if !ok {
+			if err := p.s.Err(); err != nil {
+				return Trace{}, err
+			}
+			return t, nil
+		}
+		switch {
+		case w == "$timescale":
+			words, err := p.section(w)
+			if err != nil {
+				return Trace{}, err
+			}
+			if err := p.timescale(strings.Join(words, "")); err != nil {
+				return Trace{}, err
+			}
+		case w == "$var":
+			words, err := p.section(w)
+			if err != nil {
+				return Trace{}, err
+			}
+			if len(words) < 4 {
+				return Trace{}, p.errorf("invalid $var")
+			}
+			if words[1] == "1" {
+				p.vars = append(p.vars, vcdVar{words[2], strings.Join(p.scopes, "."), words[3]})
+				p.signals = nil
+			}
+		case w == "$scope":
+			words, err := p.section(w)
+			if err != nil {
+				return Trace{}, err
+			}
+			if len(words) < 2 {
+				return Trace{}, p.errorf("invalid $scope")
+			}
+			p.scopes = append(p.scopes, words[1])
+		case w == "$upscope":
+			if _, err := p.section(w); err != nil {
+				return Trace{}, err
+			}
+			if len(p.scopes) != 0 {
+				p.scopes = p.scopes[:len(p.scopes)-1]
+			}
+		case w == "$dumpvars", w == "$dumpall", w == "$dumpon", w == "$dumpoff", w == "$end":
+			// the value changes within these sections are parsed as normal
+		case w[0] == '$':
+			if _, err := p.section(w); err != nil {
+				return Trace{}, err
+			}
+		case w[0] == '#':
+			v, err := strconv.ParseInt(w[1:], 10, 64)
+			if err != nil || v < 0 {
+				return Trace{}, p.errorf("invalid timestamp: %s", w)
+			}
+			now = time.Duration(math.Round(float64(v) * p.tick / 1e6))
+		case w[0] == '0' || w[0] == '1':
+			if name, ok := p.names()[w[1:]]; ok {
+				t.Events = append(t.Events, TraceEvent{Time: now, Signal: name, Level: int(w[0] - '0')})
+			}
+		case w[0] == 'x' || w[0] == 'X' || w[0] == 'z' || w[0] == 'Z':
+			// unknown or high impedance
+		case w[0] == 'b' || w[0] == 'B' || w[0] == 'r' || w[0] == 'R':
+			// vector or real, followed by the identifier code
+			if _, ok := p.next(); !ok {
+				return Trace{}, p.errorf("missing identifier for %s", w)
+			}
+		default:
+			return Trace{}, p.errorf("unexpected '%s'", w)
+		}
+	}
+}
+
+// timescale parses a timescale, such as "10ns".
+func (p *vcdParser) timescale(ts string) error {
+	units := []struct {
+		suffix string
+		fs     float64
+	}{
+		{"fs", 1},
+		{"ps", 1e3},
+		{"ns", 1e6},
+		{"us", 1e9},
+		{"ms", 1e12},
+		{"s", 1e15},
+	}
+	for _, u := range units {
+		if !strings.HasSuffix(ts, u.suffix) {
+			continue
+		}
+		scale, err := strconv.Atoi(strings.TrimSuffix(ts, u.suffix))
+		if err != nil || (scale != 1 && scale != 10 && scale != 100) {
+			break
+		}
+		p.tick = float64(scale) * u.fs
+		return nil
+	}
+	return p.errorf("invalid timescale: %s", ts)
+}
+
+// TraceOption defines the interface required to provide an option to
+// PlayTrace.
+type TraceOption interface {
+	applyTraceOption(*tracePlayer)
+}
+
+// PlayTrace plays the trace on the lines of the sim, with each signal played
+// on the line with the same name.
+//
+// Signals qualified by the scope of a chip, as per the recordings made by
+// RecordVCD of chips with lines of the same name, are played on the line
+// with that name on that chip.
+//
+// Refer to Chip.PlayTrace for details.
+func (s *Sim) PlayTrace(ctx context.Context, t Trace, options ...TraceOption) (*Playback, error) {
+	return playTrace(ctx, t, s.findSignal, options)
+}
+
+// findSignal returns the line to play the signal on, falling back to
+// the line named within the scope of a chip if the signal is qualified by
+// that scope.
+func (s *Sim) findSignal(signal string) (Line, error) {
+	l, err := s.FindLine(signal)
+	if !errors.Is(err, ErrLineNotFound) {
+		return l, err
+	}
+	var lines []Line
+	for i := range s.Chips {
+		if name, ok := s.Chips[i].unscoped(signal); ok {
+			lines = append(lines, s.Chips[i].FindLines(name)...)
+		}
+	}
+	if len(lines) == 0 {
+		return l, err
+	}
+	return uniqueLine(signal, lines)
+}
+
+// PlayTrace plays the trace on the lines of the chip, with each signal
+// played on the line with the same name, or, if the signal is qualified by
+// the scope of the chip, such as "fish.apple", the line named within that
+// scope.
+//
+// The mapping may be overridden, or extended, with WithSignalLine.
+//
+// If the trace contains both a "<name>" and a "<name>_pull" signal, as per
+// the recordings made by RecordVCD, then the pull signal is played on the
+// line mapped to the name and the level signal is not played, as the level
+// either follows the pull or was driven by userspace.
+// This does not apply if the pull signal is mapped with WithSignalLine.
+//
+// Returns a LineNotFoundError or AmbiguousLineError if a signal does not
+// map to exactly one line, unless WithUnmatchedSignalsIgnored is provided.
+//
+// The events are played relative to the first event in the trace, with
+// their times multiplied by the factor set by WithTimeScale, and are
+// scheduled as per Line.Play.
+func (c *Chip) PlayTrace(ctx context.Context, t Trace, options ...TraceOption) (*Playback, error) {
+	return playTrace(ctx, t, c.findSignal, options)
+}
+
+// findSignal returns the line to play the signal on, falling back to
+// the line named within the scope of the chip if the signal is qualified by
+// that scope.
+func (c *Chip) findSignal(signal string) (Line, error) {
+	l, err := c.FindLine(signal)
+	if !errors.Is(err, ErrLineNotFound) {
+		return l, err
+	}
+	if name, ok := c.unscoped(signal); ok {
+		if lines := c.FindLines(name); len(lines) != 0 {
+			return uniqueLine(signal, lines)
+		}
+	}
+	return l, err
+}
+
+// tracePlayer contains the information required to play a trace.
+type tracePlayer struct {
+	// The lines to play signals on, keyed by signal name.
+	lines map[string]Line
+
+	// The factor applied to the times of events.
+	timeScale float64
+
+	// If set then signals that do not map to a line are ignored.
+	ignoreUnmatched bool
+}
+
+func playTrace(ctx context.Context, t Trace, find func(string) (Line, error), options []TraceOption) (*Playback, error) {
+	tp := tracePlayer{
+		lines:     make(map[string]Line),
+		timeScale: 1,
+	}
+	for _, option := range options {
+		option.applyTraceOption(&tp)
+	}
+	if tp.timeScale <= 0 || math.IsNaN(tp.timeScale) || math.IsInf(tp.timeScale, 0) {
+		return nil, errors.Errorf("invalid time scale: %g", tp.timeScale)
+	}
+	mapped := make(map[string]bool)
+	for signal := range tp.lines {
+		mapped[signal] = true
+	}
+	signals := t.Signals()
+	inTrace := make(map[string]bool)
+	for _, signal := range signals {
+		inTrace[signal] = true
+	}
+	for _, signal := range signals {
+		if _, ok := tp.lines[signal]; ok {
+			continue
+		}
+		if _, ok := pairedLevel(signal, inTrace); ok {
+			// played on the line of its level signal
+			continue
+		}
+		l, err := find(signal)
+		if err != nil {
+			if tp.ignoreUnmatched {
+				continue
+			}
+			return nil, err
+		}
+		tp.lines[signal] = l
+	}
+	// play the pull signals in place of their level signals
+	for _, signal := range signals {
+		level, ok := pairedLevel(signal, inTrace)
+		if !ok || mapped[signal] {
+			continue
+		}
+		if l, ok := tp.lines[level]; ok {
+			tp.lines[signal] = l
+			delete(tp.lines, level)
+		}
+	}
+	p := Playback{
+		done:        make(chan struct{}),
+		transitions: make([]Transition, 0, len(t.Events)),
+	}
+	go func() {
+		defer close(p.done)
+		p.err = tp.play(ctx, t.Events, &p.transitions)
+	}()
+	return &p, nil
+}
+
+// pairedLevel returns the name of the level signal paired with the signal, if
+// the signal is a pull signal, as recorded by RecordVCD, and the level signal
+// is also in the trace.
+func pairedLevel(signal string, inTrace map[string]bool) (string, bool) {
+	level := strings.TrimSuffix(signal, pullSuffix)
+	return level, level != signal && inTrace[level]
+}
+
+func (tp *tracePlayer) play(ctx context.Context, events []TraceEvent, transitions *[]Transition) error {
+	if len(events) == 0 {
+		return nil
+	}
+	sch := newScheduler(ctx)
+	defer sch.close()
+	start := time.Now()
+	origin := events[0].Time
+	for _, e := range events {
+		l, ok := tp.lines[e.Signal]
+		if !ok {
+			continue
+		}
+		deadline := start.Add(time.Duration(float64(e.Time-origin) * tp.timeScale))
+		if err := sch.sleepUntil(deadline); err != nil {
+			return err
+		}
+		if err := l.SetPull(e.Level); err != nil {
+			return err
+		}
+		*transitions = append(*transitions, Transition{
+			Line:      l,
+			Level:     e.Level,
+			Scheduled: deadline,
+			Timestamp: time.Now(),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warthog618/go-gpiosim"
+)
+
+func TestParseVCD(t *testing.T) {
+	vcd := `$date today $end
+$version some analyzer $end
+$comment
+  multi-line comment
+$end
+$timescale 10 us $end
+$scope module top $end
+$var wire 1 ! BUTTON $end
+$var wire 1 " LED $end
+$var wire 8 # bus $end
+$upscope $end
+$enddefinitions $end
+#0
+$dumpvars
+0!
+x"
+b00000000 #
+$end
+#5
+1!
+1"
+b00000001 #
+#12
+z!
+#20 0!
+`
+	trace, err := gpiosim.ParseVCD(strings.NewReader(vcd))
+	require.Nil(t, err)
+	assert.Equal(t, []gpiosim.TraceEvent{
+		{Time: 0, Signal: "BUTTON", Level: 0},
+		{Time: 50 * time.Microsecond, Signal: "BUTTON", Level: 1},
+		{Time: 50 * time.Microsecond, Signal: "LED", Level: 1},
+		{Time: 200 * time.Microsecond, Signal: "BUTTON", Level: 0},
+	}, trace.Events)
+	assert.Equal(t, []string{"BUTTON", "LED"}, trace.Signals())
+
+	// references in different scopes are qualified by their scope
+	vcd = `$scope module fish $end
+$var wire 1 ! apple $end
+$var wire 1 " pear $end
+$upscope $end
+$scope module babel $end
+$var wire 1 # apple $end
+$upscope $end
+$enddefinitions $end
+#0
+1!
+1"
+0#
+`
+	trace, err = gpiosim.ParseVCD(strings.NewReader(vcd))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"fish.apple", "pear", "babel.apple"}, trace.Signals())
+
+	patterns := []struct {
+		name string
+		vcd  string
+		err  string
+	}{
+		{"timescale", "$timescale 3 ns $end", "line 1: invalid timescale: 3ns"},
+		{"timescale unit", "$timescale 1 ks $end", "line 1: invalid timescale: 1ks"},
+		{"var", "$var wire 1 ! $end", "line 1: invalid $var"},
+		{"scope", "$scope $end", "line 1: invalid $scope"},
+		{"missing end", "$comment\nno end\n", "line 2: missing $end for $comment"},
+		{"timestamp", "\n#-5", "line 2: invalid timestamp: #-5"},
+		{"vector", "#0\nb0101", "line 2: missing identifier for b0101"},
+		{"unexpected", "#0\n\n2!", "line 3: unexpected '2!'"},
+	}
+	for _, p := range patterns {
+		tf := func(t *testing.T) {
+			_, err := gpiosim.ParseVCD(strings.NewReader(p.vcd))
+			assert.ErrorIs(t, err, gpiosim.ErrInvalidTrace)
+			require.NotNil(t, err)
+			assert.Equal(t, p.err, err.Error())
+		}
+		t.Run(p.name, tf)
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	csv := `time,signal,level
+# comment
+0.0, BUTTON, 0
+0.0015, LED, 1
+0.001, BUTTON, 1
+`
+	trace, err := gpiosim.ParseCSV(strings.NewReader(csv))
+	require.Nil(t, err)
+	assert.Equal(t, []gpiosim.TraceEvent{
+		{Time: 0, Signal: "BUTTON", Level: 0},
+		{Time: time.Millisecond, Signal: "BUTTON", Level: 1},
+		{Time: 1500 * time.Microsecond, Signal: "LED", Level: 1},
+	}, trace.Events)
+
+	patterns := []struct {
+		name string
+		csv  string
+		err  string
+	}{
+		{"timestamp", "0,A,1\nsoon,A,0\n", "line 2: invalid timestamp: soon"},
+		{"level", "0,A,1\n1,A,high\n", "line 2: invalid level: high"},
+		{"fields", "0,A,1\n1,A\n", "line 2: wrong number of fields"},
+	}
+	for _, p := range patterns {
+		tf := func(t *testing.T) {
+			_, err := gpiosim.ParseCSV(strings.NewReader(p.csv))
+			assert.ErrorIs(t, err, gpiosim.ErrInvalidTrace)
+			require.NotNil(t, err)
+			assert.Equal(t, p.err, err.Error())
+		}
+		t.Run(p.name, tf)
+	}
+}
+
+func TestLoadTrace(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "trace.CSV")
+	require.Nil(t, os.WriteFile(csvPath, []byte("0,A,1\n0.5,A,0\n"), 0644))
+	trace, err := gpiosim.LoadTrace(csvPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(trace.Events))
+
+	vcdPath := filepath.Join(dir, "trace.vcd")
+	require.Nil(t, os.WriteFile(vcdPath, []byte("$var wire 1 ! A $end\n#0 1!\n#7 0!\n"), 0644))
+	trace, err = gpiosim.LoadTrace(vcdPath)
+	assert.Nil(t, err)
+	assert.Equal(t, []gpiosim.TraceEvent{
+		{Time: 0, Signal: "A", Level: 1},
+		{Time: 7 * time.Nanosecond, Signal: "A", Level: 0},
+	}, trace.Events)
+
+	badPath := filepath.Join(dir, "bad.vcd")
+	require.Nil(t, os.WriteFile(badPath, []byte("#0\n?\n"), 0644))
+	_, err = gpiosim.LoadTrace(badPath)
+	assert.ErrorIs(t, err, gpiosim.ErrInvalidTrace)
+	assert.Equal(t, gpiosim.TraceError{File: badPath, Line: 2, Reason: "unexpected '?'"}, err)
+
+	_, err = gpiosim.LoadTrace(filepath.Join(dir, "missing.vcd"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,276 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// The default period between samples of the recorded lines.
+const defaultSamplePeriod = time.Millisecond
+
+// pullSuffix is appended to the name of a line to name the signal recording
+// its pull.
+const pullSuffix = "_pull"
+
+// RecordOption defines the interface required to provide an option to
+// RecordVCD.
+type RecordOption interface {
+	applyRecordOption(*recorder)
+}
+
+// Recording is a recording of lines in progress.
+type Recording struct {
+	done chan struct{}
+	err  error
+}
+
+// Done returns a channel that is closed when the recording finishes.
+func (r *Recording) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait waits for the recording to finish.
+//
+// Returns nil if the recording was stopped by its context, else the error
+// that stopped it.
+func (r *Recording) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// RecordVCD records the lines at the given offsets, in IEEE 1364 Value Change
+// Dump format, until the context is done.
+//
+// If no offsets are provided then all the lines of the chip are recorded.
+//
+// Refer to RecordVCD for details.
+func (c *Chip) RecordVCD(ctx context.Context, w io.Writer, offsets []int, options ...RecordOption) (*Recording, error) {
+	if len(offsets) == 0 {
+		offsets = make([]int, c.cfg.NumLines)
+		for i := range offsets {
+			offsets[i] = i
+		}
+	}
+	lines := make([]Line, len(offsets))
+	for i, o := range offsets {
+		l, err := c.Line(o)
+		if err != nil {
+			return nil, err
+		}
+		lines[i] = l
+	}
+	return RecordVCD(ctx, w, lines, options...)
+}
+
+// RecordVCD records the lines, in IEEE 1364 Value Change Dump format, until
+// the context is done.
+//
+// The recording is written to w, and may be viewed with tools such as
+// GTKWave.
+//
+// Each line is recorded as two signals - its level, which is driven by
+// userspace if the line is an output, and its pull, which is the level
+// seen by userspace if the line is an input.
+// The signals are named after the line, or "line<offset>" for unnamed lines,
+// with a "_pull" suffix for the pull, and are grouped into a scope for each
+// chip, named after its label, or its name if it has no label.
+// Whitespace in names is replaced with underscores.
+//
+// The recording may be replayed by parsing it with ParseVCD and playing it
+// with Sim.PlayTrace, which plays the pull signals on their lines.
+// Lines with the same name on different chips are distinguished in the
+// parsed trace by the scope of their chip, e.g. "fish.apple", and are played
+// back on the line of that name on the chip with that label.
+// Unnamed lines, and lines with names containing whitespace, must be mapped
+// to their signals with WithSignalLine.
+//
+// The lines are sampled periodically, as set by WithSamplePeriod, so changes
+// shorter than that period may be missed.  Times are recorded in
+// microseconds from the start of the recording.
+//
+// The lines may be from different chips, and the recording may be stopped by
+// cancelling the context.
+func RecordVCD(ctx context.Context, w io.Writer, lines []Line, options ...RecordOption) (*Recording, error) {
+	if len(lines) == 0 {
+		return nil, errors.New("no lines to record")
+	}
+	r := recorder{
+		w:            bufio.NewWriter(w),
+		lines:        lines,
+		samplePeriod: defaultSamplePeriod,
+	}
+	for _, option := range options {
+		option.applyRecordOption(&r)
+	}
+	if r.samplePeriod <= 0 {
+		r.samplePeriod = defaultSamplePeriod
+	}
+	values, err := r.sample()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	r.writeHeader(start)
+	r.writeValues(0, nil, values)
+	if err := r.w.Flush(); err != nil {
+		return nil, err
+	}
+	rec := Recording{done: make(chan struct{})}
+	go func() {
+		defer close(rec.done)
+		rec.err = r.run(ctx, start, values)
+	}()
+	return &rec, nil
+}
+
+// recorder samples lines and writes the changes in VCD format.
+type recorder struct {
+	w            *bufio.Writer
+	lines        []Line
+	samplePeriod time.Duration
+}
+
+// sample returns the level and pull of each line, in that order.
+func (r *recorder) sample() ([]int, error) {
+	values := make([]int, 0, 2*len(r.lines))
+	for _, l := range r.lines {
+		v, err := l.Level()
+		if err != nil {
+			return nil, err
+		}
+		p, err := l.Pull()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v, p)
+	}
+	return values, nil
+}
+
+func (r *recorder) run(ctx context.Context, start time.Time, values []int) error {
+	ticker := time.NewTicker(r.samplePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// mark the end of the recording, so the final values are shown
+			fmt.Fprintf(r.w, "#%d\n", time.Since(start).Microseconds())
+			return r.w.Flush()
+		case now := <-ticker.C:
+			sample, err := r.sample()
+			if err != nil {
+				r.w.Flush()
+				return err
+			}
+			r.writeValues(now.Sub(start).Microseconds(), values, sample)
+			values = sample
+			if err := r.w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *recorder) writeHeader(start time.Time) {
+	fmt.Fprintf(r.w, "$date\n\t%s\n$end\n", start.Format(time.RFC1123))
+	fmt.Fprintf(r.w, "$version\n\tgo-gpiosim\n$end\n")
+	fmt.Fprintf(r.w, "$timescale 1 us $end\n")
+	var chip *Chip
+	for i, l := range r.lines {
+		if l.chip != chip {
+			if chip != nil {
+				fmt.Fprintf(r.w, "$upscope $end\n")
+			}
+			chip = l.chip
+			fmt.Fprintf(r.w, "$scope module %s $end\n", chip.vcdScope())
+		}
+		name := l.Name()
+		if len(name) == 0 {
+			name = fmt.Sprintf("line%d", l.offset)
+		}
+		name = vcdName(name)
+		fmt.Fprintf(r.w, "$var wire 1 %s %s $end\n", vcdID(2*i), name)
+		fmt.Fprintf(r.w, "$var wire 1 %s %s%s $end\n", vcdID(2*i+1), name, pullSuffix)
+	}
+	fmt.Fprintf(r.w, "$upscope $end\n$enddefinitions $end\n")
+}
+
+// writeValues writes the values that differ from the previous values.
+//
+// If there are no previous values then all the values are written as the
+// initial values.
+func (r *recorder) writeValues(t int64, prev, values []int) {
+	if prev == nil {
+		fmt.Fprintf(r.w, "#%d\n$dumpvars\n", t)
+		for i, v := range values {
+			fmt.Fprintf(r.w, "%d%s\n", v, vcdID(i))
+		}
+		fmt.Fprintf(r.w, "$end\n")
+		return
+	}
+	changed := false
+	for i, v := range values {
+		if v == prev[i] {
+			continue
+		}
+		if !changed {
+			fmt.Fprintf(r.w, "#%d\n", t)
+			changed = true
+		}
+		fmt.Fprintf(r.w, "%d%s\n", v, vcdID(i))
+	}
+}
+
+// vcdID returns the VCD identifier code for the nth signal.
+//
+// Identifiers are composed of the printable ASCII characters, '!' to '~'.
+func vcdID(n int) string {
+	const first, base = '!', '~' - '!' + 1
+	id := []byte{byte(first + n%base)}
+	for n /= base; n > 0; n /= base {
+		n--
+		id = append(id, byte(first+n%base))
+	}
+	return string(id)
+}
+
+// vcdScope returns the name of the scope containing the signals of the chip,
+// which is its label, or its name if it has no label.
+func (c *Chip) vcdScope() string {
+	if len(c.cfg.Label) == 0 {
+		return vcdName(c.chipName)
+	}
+	return vcdName(c.cfg.Label)
+}
+
+// unscoped returns the name of a signal qualified by the scope of the chip,
+// without the scope.
+func (c *Chip) unscoped(signal string) (string, bool) {
+	prefix := c.vcdScope() + "."
+	if !strings.HasPrefix(signal, prefix) {
+		return "", false
+	}
+	return signal[len(prefix):], true
+}
+
+// vcdName returns the name with whitespace, which is not permitted in VCD
+// references, replaced with underscores.
+func vcdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
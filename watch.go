@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The default period between checks of the levels of watched lines.
+const defaultLevelPollInterval = 10 * time.Millisecond
+
+// LevelEvent describes a change to the level of a line.
+type LevelEvent struct {
+	// The offset of the line.
+	Offset int
+
+	// The new level of the line.
+	Level int
+
+	// The time the change was detected.
+	Timestamp time.Time
+}
+
+// WatchLevel watches the levels of the lines at the given offsets, returning
+// a channel that receives an event each time a level changes.
+//
+// If no offsets are provided then all the lines of the chip are watched.
+//
+// Where the backend supports notification of level changes, such as sysfs
+// poll on the value attribute, changes are detected as they occur.
+// Otherwise the levels are checked periodically, as set by
+// [WithLevelPollInterval], so changes shorter than that period may be missed.
+//
+// The watch continues until the context is cancelled or reading a level
+// fails, such as when the sim is closed, after which the channel is closed.
+func (c *Chip) WatchLevel(ctx context.Context, offsets ...int) (<-chan LevelEvent, error) {
+	if len(offsets) == 0 {
+		offsets = make([]int, c.cfg.NumLines)
+		for i := range offsets {
+			offsets[i] = i
+		}
+	}
+	lines := make([]Line, len(offsets))
+	levels := make([]int, len(offsets))
+	for i, o := range offsets {
+		l, err := c.Line(o)
+		if err != nil {
+			return nil, err
+		}
+		lines[i] = l
+	}
+	var w levelWaiter = pollWaiter{}
+	if n, ok := c.lines.(levelNotifier); ok {
+		if nw, err := n.notifier(offsets); err == nil {
+			w = nw
+		}
+	}
+	// the initial levels are read after the notifier is armed, so no
+	// changes are missed
+	for i, l := range lines {
+		v, err := l.Level()
+		if err != nil {
+			w.close()
+			return nil, err
+		}
+		levels[i] = v
+	}
+	interval := c.pollInterval()
+	ch := make(chan LevelEvent, len(offsets))
+	go func() {
+		defer close(ch)
+		defer w.close()
+		for {
+			w.wait(ctx, interval)
+			if ctx.Err() != nil {
+				return
+			}
+			now := time.Now()
+			for i, l := range lines {
+				v, err := l.Level()
+				if err != nil {
+					return
+				}
+				if v == levels[i] {
+					continue
+				}
+				levels[i] = v
+				select {
+				case ch <- LevelEvent{Offset: l.offset, Level: v, Timestamp: now}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// pollInterval returns the period between checks of the levels of watched
+// lines.
+func (c *Chip) pollInterval() time.Duration {
+	if c.levelPollInterval <= 0 {
+		return defaultLevelPollInterval
+	}
+	return c.levelPollInterval
+}
+
+// levelNotifier is implemented by lineAttrs that can notify of changes to
+// the levels of lines.
+type levelNotifier interface {
+	// notifier returns a waiter that is woken by changes to the levels of
+	// the lines at the given offsets.
+	notifier(offsets []int) (levelWaiter, error)
+}
+
+// levelWaiter waits for the levels of lines to possibly change.
+type levelWaiter interface {
+	// wait waits for a possible change, the timeout to expire, or the context
+	// to be done.
+	//
+	// Spurious wakeups are possible, so the caller must recheck the levels.
+	wait(ctx context.Context, timeout time.Duration)
+
+	close()
+}
+
+// pollWaiter waits for the timeout, so the levels are polled.
+type pollWaiter struct{}
+
+func (pollWaiter) wait(ctx context.Context, timeout time.Duration) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+func (pollWaiter) close() {}
+
+func (p sysfsLines) notifier(offsets []int) (levelWaiter, error) {
+	return newSysfsWaiter(string(p), offsets)
+}
+
+func (p *cachedSysfsLines) notifier(offsets []int) (levelWaiter, error) {
+	return newSysfsWaiter(p.path, offsets)
+}
+
+// sysfsWaiter waits for sysfs to notify of changes to the value attributes of
+// lines.
+//
+// If the kernel does not notify of changes then the waiter times out, and so
+// falls back to polling.
+type sysfsWaiter struct {
+	files []*os.File
+	fds   []unix.PollFd
+}
+
+func newSysfsWaiter(chipPath string, offsets []int) (*sysfsWaiter, error) {
+	w := sysfsWaiter{}
+	for _, o := range offsets {
+		f, err := os.Open(path.Join(chipPath, fmt.Sprintf("sim_gpio%d", o), "value"))
+		if err != nil {
+			w.close()
+			return nil, err
+		}
+		w.files = append(w.files, f)
+		w.fds = append(w.fds, unix.PollFd{Fd: int32(f.Fd()), Events: unix.POLLPRI | unix.POLLERR})
+		// the attribute must be read before sysfs will notify of changes
+		w.rearm(f)
+	}
+	return &w, nil
+}
+
+func (w *sysfsWaiter) rearm(f *os.File) {
+	buf := make([]byte, 64)
+	f.ReadAt(buf, 0)
+}
+
+// wait waits for a notification from sysfs, or the timeout to expire.
+//
+// The context is only checked when the poll returns, so cancellation may
+// take up to the timeout to be noticed.
+func (w *sysfsWaiter) wait(ctx context.Context, timeout time.Duration) {
+	if ctx.Err() != nil {
+		return
+	}
+	// round up so a sub-millisecond timeout does not spin
+	ms := int((timeout + time.Millisecond - 1) / time.Millisecond)
+	if n, err := unix.Poll(w.fds, ms); err != nil || n == 0 {
+		return
+	}
+	for i := range w.fds {
+		if w.fds[i].Revents != 0 {
+			w.rearm(w.files[i])
+		}
+	}
+}
+
+func (w *sysfsWaiter) close() {
+	for _, f := range w.files {
+		f.Close()
+	}
+	w.files = nil
+}
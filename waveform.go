@@ -0,0 +1,245 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Step is one step of a waveform - a level that is held for a duration.
+type Step struct {
+	// The level to pull the line to.
+	Level int
+
+	// The period the level is held before the next step.
+	Duration time.Duration
+}
+
+// Transition describes a step of a waveform applied to a line.
+type Transition struct {
+	// The line the step was applied to.
+	Line Line
+
+	// The level the line was pulled to.
+	Level int
+
+	// The time the step was scheduled to be applied.
+	Scheduled time.Time
+
+	// The time the step was applied, immediately after the pull was set.
+	Timestamp time.Time
+}
+
+// Pulse returns the steps of a single pulse to the level, of the given width,
+// after which the line returns to the opposite level.
+func Pulse(level int, width time.Duration) []Step {
+	return []Step{
+		{Level: level, Duration: width},
+		{Level: level ^ 1},
+	}
+}
+
+// SquareWave returns the steps of a square wave with the given period, that
+// starts active and ends inactive.
+//
+// Odd periods are split with the extra nanosecond going to the inactive half.
+func SquareWave(period time.Duration, cycles int) []Step {
+	high := period / 2
+	steps := make([]Step, 0, 2*cycles)
+	for i := 0; i < cycles; i++ {
+		steps = append(steps,
+			Step{Level: LevelActive, Duration: high},
+			Step{Level: LevelInactive, Duration: period - high})
+	}
+	return steps
+}
+
+// Burst returns the steps of a burst of active pulses of the given width,
+// separated by inactive gaps.
+//
+// The line is left inactive after the last pulse.
+func Burst(pulses int, width, gap time.Duration) []Step {
+	steps := make([]Step, 0, 2*pulses)
+	for i := 0; i < pulses; i++ {
+		steps = append(steps, Step{Level: LevelActive, Duration: width})
+		if i < pulses-1 {
+			steps = append(steps, Step{Level: LevelInactive, Duration: gap})
+		}
+	}
+	if pulses > 0 {
+		steps = append(steps, Step{Level: LevelInactive})
+	}
+	return steps
+}
+
+// BounceConfig defines the contact bounce generated by Bounce.
+type BounceConfig struct {
+	// The number of times the contact bounces away from the target level
+	// before settling.
+	Bounces int
+
+	// The minimum period between transitions while bouncing.
+	MinInterval time.Duration
+
+	// The maximum period between transitions while bouncing.
+	//
+	// If less than MinInterval then MinInterval is used.
+	MaxInterval time.Duration
+
+	// The period the line is held at the target level once settled.
+	Settle time.Duration
+
+	// The seed for the random intervals.
+	//
+	// The same seed always generates the same steps.
+	Seed int64
+}
+
+// Bounce returns the steps of a contact bouncing as it moves to the level,
+// such as a button being pressed or released.
+//
+// The line moves to the level, and then bounces away from and back to it the
+// configured number of times, with each transition separated by a random
+// interval in the range MinInterval..MaxInterval.
+// Finally the line settles at the level for the Settle period.
+func Bounce(level int, cfg BounceConfig) []Step {
+	r := rand.New(rand.NewSource(cfg.Seed))
+	interval := func() time.Duration {
+		if cfg.MaxInterval <= cfg.MinInterval {
+			return cfg.MinInterval
+		}
+		return cfg.MinInterval + time.Duration(r.Int63n(int64(cfg.MaxInterval-cfg.MinInterval)+1))
+	}
+	steps := make([]Step, 0, 2*cfg.Bounces+1)
+	for i := 0; i < cfg.Bounces; i++ {
+		steps = append(steps,
+			Step{Level: level, Duration: interval()},
+			Step{Level: level ^ 1, Duration: interval()})
+	}
+	return append(steps, Step{Level: level, Duration: cfg.Settle})
+}
+
+// Playback is a waveform being played on a line.
+type Playback struct {
+	done        chan struct{}
+	transitions []Transition
+	err         error
+}
+
+// Done returns a channel that is closed when the playback finishes.
+func (p *Playback) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait waits for the playback to finish, and returns the transitions that
+// were applied.
+//
+// The error is the context error if the playback was cancelled, or the error
+// from setting the pull if that failed.
+func (p *Playback) Wait() ([]Transition, error) {
+	<-p.done
+	return p.transitions, p.err
+}
+
+// Play plays the waveform on the line at the given offset.
+//
+// Refer to Line.Play for details.
+func (c *Chip) Play(ctx context.Context, offset int, steps []Step) (*Playback, error) {
+	l, err := c.Line(offset)
+	if err != nil {
+		return nil, err
+	}
+	return l.Play(ctx, steps)
+}
+
+// Play plays the waveform on the line, pulling it to the level of each step
+// in turn.
+//
+// The waveform is played in a goroutine, and Play returns once it has
+// started.  The steps are scheduled relative to the start of the playback,
+// rather than the previous step, so delays applying one step do not
+// accumulate over the waveform.
+// If a step is applied late then the following steps are still applied at
+// their scheduled times, which may be immediately.
+//
+// The playback finishes after the duration of the last step, or when the
+// context is cancelled, and the line is left at the level of the last step
+// applied.
+func (l Line) Play(ctx context.Context, steps []Step) (*Playback, error) {
+	for i, s := range steps {
+		if s.Level != LevelInactive && s.Level != LevelActive {
+			return nil, StepError{i, "invalid level"}
+		}
+		if s.Duration < 0 {
+			return nil, StepError{i, "negative duration"}
+		}
+	}
+	p := Playback{
+		done:        make(chan struct{}),
+		transitions: make([]Transition, 0, len(steps)),
+	}
+	go func() {
+		defer close(p.done)
+		p.err = l.play(ctx, steps, &p.transitions)
+	}()
+	return &p, nil
+}
+
+func (l Line) play(ctx context.Context, steps []Step, transitions *[]Transition) error {
+	sch := newScheduler(ctx)
+	defer sch.close()
+	deadline := time.Now()
+	for _, s := range steps {
+		if err := sch.sleepUntil(deadline); err != nil {
+			return err
+		}
+		if err := l.SetPull(s.Level); err != nil {
+			return err
+		}
+		*transitions = append(*transitions, Transition{
+			Line:      l,
+			Level:     s.Level,
+			Scheduled: deadline,
+			Timestamp: time.Now(),
+		})
+		deadline = deadline.Add(s.Duration)
+	}
+	return sch.sleepUntil(deadline)
+}
+
+// scheduler sleeps until deadlines, unless the context is done first.
+type scheduler struct {
+	ctx   context.Context
+	timer *time.Timer
+}
+
+func newScheduler(ctx context.Context) scheduler {
+	t := time.NewTimer(0)
+	<-t.C
+	return scheduler{ctx, t}
+}
+
+// sleepUntil sleeps until the deadline, returning immediately if the
+// deadline has already passed.
+//
+// Returns the context error if the context is done before the deadline.
+func (s scheduler) sleepUntil(deadline time.Time) error {
+	s.timer.Reset(time.Until(deadline))
+	select {
+	case <-s.timer.C:
+		return nil
+	case <-s.ctx.Done():
+		if !s.timer.Stop() {
+			<-s.timer.C
+		}
+		return s.ctx.Err()
+	}
+}
+
+func (s scheduler) close() {
+	s.timer.Stop()
+}
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/warthog618/go-gpiosim"
+)
+
+func TestWaveforms(t *testing.T) {
+	ms := time.Millisecond
+	patterns := []struct {
+		name     string
+		steps    []gpiosim.Step
+		expected []gpiosim.Step
+	}{
+		{
+			"pulse high",
+			gpiosim.Pulse(gpiosim.LevelActive, 5*ms),
+			[]gpiosim.Step{{1, 5 * ms}, {0, 0}},
+		},
+		{
+			"pulse low",
+			gpiosim.Pulse(gpiosim.LevelInactive, 5*ms),
+			[]gpiosim.Step{{0, 5 * ms}, {1, 0}},
+		},
+		{
+			"square",
+			gpiosim.SquareWave(4*ms, 2),
+			[]gpiosim.Step{{1, 2 * ms}, {0, 2 * ms}, {1, 2 * ms}, {0, 2 * ms}},
+		},
+		{
+			"square odd",
+			gpiosim.SquareWave(3, 1),
+			[]gpiosim.Step{{1, 1}, {0, 2}},
+		},
+		{
+			"square none",
+			gpiosim.SquareWave(4*ms, 0),
+			[]gpiosim.Step{},
+		},
+		{
+			"burst",
+			gpiosim.Burst(3, ms, 2*ms),
+			[]gpiosim.Step{{1, ms}, {0, 2 * ms}, {1, ms}, {0, 2 * ms}, {1, ms}, {0, 0}},
+		},
+		{
+			"burst single",
+			gpiosim.Burst(1, ms, 2*ms),
+			[]gpiosim.Step{{1, ms}, {0, 0}},
+		},
+		{
+			"burst none",
+			gpiosim.Burst(0, ms, 2*ms),
+			[]gpiosim.Step{},
+		},
+	}
+	for _, p := range patterns {
+		tf := func(t *testing.T) {
+			assert.Equal(t, p.expected, p.steps)
+		}
+		t.Run(p.name, tf)
+	}
+}
+
+func TestBounce(t *testing.T) {
+	ms := time.Millisecond
+	cfg := gpiosim.BounceConfig{
+		Bounces:     4,
+		MinInterval: ms,
+		MaxInterval: 3 * ms,
+		Settle:      20 * ms,
+		Seed:        42,
+	}
+	steps := gpiosim.Bounce(gpiosim.LevelActive, cfg)
+	require.Equal(t, 9, len(steps))
+	for i, s := range steps[:8] {
+		assert.Equal(t, (i+1)%2, s.Level)
+		assert.GreaterOrEqual(t, s.Duration, ms)
+		assert.LessOrEqual(t, s.Duration, 3*ms)
+	}
+	assert.Equal(t, gpiosim.Step{Level: gpiosim.LevelActive, Duration: 20 * ms}, steps[8])
+
+	// reproducible
+	assert.Equal(t, steps, gpiosim.Bounce(gpiosim.LevelActive, cfg))
+	cfg.Seed = 43
+	assert.NotEqual(t, steps, gpiosim.Bounce(gpiosim.LevelActive, cfg))
+
+	// release
+	steps = gpiosim.Bounce(gpiosim.LevelInactive, cfg)
+	require.Equal(t, 9, len(steps))
+	for i, s := range steps {
+		assert.Equal(t, i%2, s.Level)
+	}
+
+	// fixed interval
+	cfg.MaxInterval = 0
+	steps = gpiosim.Bounce(gpiosim.LevelInactive, cfg)
+	for _, s := range steps[:8] {
+		assert.Equal(t, ms, s.Duration)
+	}
+
+	// no bounce
+	cfg.Bounces = 0
+	steps = gpiosim.Bounce(gpiosim.LevelActive, cfg)
+	assert.Equal(t, []gpiosim.Step{{Level: gpiosim.LevelActive, Duration: 20 * ms}}, steps)
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2023 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: Apache-2.0 OR MIT
+
+package gpiosim
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WireOption defines the interface required to provide an option to
+// Sim.Wire.
+type WireOption interface {
+	applyWireOption(*Wire)
+}
+
+// Wire connects the level of one line to the pull of another, so the level
+// driven on an output can be read back on an input.
+type Wire struct {
+	from Line
+	to   Line
+
+	// If set then the level is inverted.
+	inverted bool
+
+	// The delay between a change to the level of from and the corresponding
+	// change to the pull of to.
+	delay time.Duration
+
+	// The connections of the sim, from which the wire is removed when closed.
+	connections *connectionSet
+
+	cancel    context.CancelFunc
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+}
+
+// Wire connects the lines, mirroring the level of the from line as the pull
+// on the to line, until the Wire or the sim is closed.
+//
+// The from line is typically an output requested by the code under test,
+// and the to line an input.
+// The lines may be on different chips, but both must be in the sim.
+//
+// The level of the from line is watched as per Chip.WatchLevel, and the pull
+// of the to line is set immediately to match the current level.
+// The available options are [WithInverted] and [WithPropagationDelay].
+func (s *Sim) Wire(from, to Line, options ...WireOption) (*Wire, error) {
+	if !s.contains(from) || !s.contains(to) {
+		return nil, errors.New("line is not in sim")
+	}
+	if from.chip == to.chip && from.offset == to.offset {
+		return nil, errors.New("line wired to itself")
+	}
+	w := Wire{from: from, to: to, connections: s.connections, done: make(chan struct{})}
+	for _, option := range options {
+		option.applyWireOption(&w)
+	}
+	if w.delay < 0 {
+		return nil, errors.Errorf("invalid propagation delay: %s", w.delay)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := from.chip.WatchLevel(ctx, from.offset)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	level, err := from.Level()
+	if err == nil {
+		err = to.SetPull(w.level(level))
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	w.cancel = cancel
+	go func() {
+		defer close(w.done)
+		w.err = w.run(ctx, events)
+	}()
+	s.connections.add(&w)
+	return &w, nil
+}
+
+// contains returns true if the line is on one of the chips of the sim.
+func (s *Sim) contains(l Line) bool {
+	for i := range s.Chips {
+		if l.chip == &s.Chips[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// From returns the line providing the level.
+func (w *Wire) From() Line {
+	return w.from
+}
+
+// To returns the line being pulled.
+func (w *Wire) To() Line {
+	return w.to
+}
+
+// Done returns a channel that is closed when the wire is disconnected, either
+// by Close or by a failure to access the lines.
+func (w *Wire) Done() <-chan struct{} {
+	return w.done
+}
+
+// Close disconnects the wire, leaving the pull of the to line at its current
+// value.
+//
+// Returns the error that disconnected the wire, if it failed before being
+// closed.
+func (w *Wire) Close() error {
+	w.closeOnce.Do(func() {
+		w.cancel()
+		w.connections.remove(w)
+	})
+	<-w.done
+	return w.err
+}
+
+func (w *Wire) level(v int) int {
+	if w.inverted {
+		return v ^ 1
+	}
+	return v
+}
+
+// run applies the changes to the level of from to the pull of to, each
+// delayed from when the change was detected.
+//
+// The changes are applied in order, so the delay is a transport delay -
+// pulses shorter than the delay are propagated rather than absorbed.
+func (w *Wire) run(ctx context.Context, events <-chan LevelEvent) error {
+	sch := newScheduler(ctx)
+	defer sch.close()
+	for evt := range events {
+		if err := sch.sleepUntil(evt.Timestamp.Add(w.delay)); err != nil {
+			return nil
+		}
+		if err := w.to.SetPull(w.level(evt.Level)); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() == nil {
+		return errors.Errorf("failed to read level of line %d", w.from.offset)
+	}
+	return nil
+}